@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zhsj/wghttp/internal/proxy"
+)
+
+// accessLog writes proxy requests to a file, or client connections in
+// jsonl format.
+type accessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	// tunnel and peer identify the tunnel of connections in jsonl
+	// format, with the name and the public key of peer.
+	tunnel string
+	peer   string
+}
+
+// newAccessLog opens path for appending access log, or uses stdout if path
+// is "-".
+func newAccessLog(path, format string) (*accessLog, error) {
+	if path == "-" {
+		return &accessLog{w: os.Stdout, format: format}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLog{w: f, format: format}, nil
+}
+
+// buffer makes writes buffered by logBuffer, which is flushed at exit.
+func (l *accessLog) buffer(size int, interval time.Duration, onDrop func(int)) {
+	b := newLogBuffer(l.w, size, interval, onDrop)
+	l.w = b
+	atExit(b.Flush)
+}
+
+func (l *accessLog) log(r proxy.Request) {
+	var line string
+	switch l.format {
+	case "clf":
+		line = formatCLF(r)
+	case "combined":
+		line = formatCLF(r) + fmt.Sprintf(" %q %q", orDash(r.Referer), orDash(r.UserAgent))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, line+"\n")
+}
+
+// logConn writes r as a JSON object in a line.
+func (l *accessLog) logConn(r proxy.Record) {
+	entry := struct {
+		Tunnel       string `json:",omitempty"`
+		Peer         string
+		ID           uint64
+		Protocol     string
+		Client       string
+		Host         string `json:",omitempty"`
+		Destination  string `json:",omitempty"`
+		Source       string `json:",omitempty"`
+		Start        time.Time
+		Duration     float64
+		DialDuration float64
+		// SentBytes is sent by client, ReceivedBytes is received by
+		// client.
+		SentBytes     int64
+		ReceivedBytes int64
+		CloseReason   string
+	}{
+		Tunnel:        l.tunnel,
+		Peer:          l.peer,
+		ID:            r.ID,
+		Protocol:      r.Protocol,
+		Client:        r.Client.String(),
+		Host:          r.Host,
+		Start:         r.Start,
+		Duration:      r.End.Sub(r.Start).Seconds(),
+		DialDuration:  r.DialDuration.Seconds(),
+		SentBytes:     r.SentBytes,
+		ReceivedBytes: r.ReceivedBytes,
+		CloseReason:   r.CloseReason,
+	}
+	if r.Destination != nil {
+		entry.Destination = r.Destination.String()
+	}
+	if r.Source != nil {
+		entry.Source = r.Source.String()
+	}
+	line, _ := json.Marshal(entry)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(append(line, '\n'))
+}
+
+// formatCLF formats r in Common Log Format.
+func formatCLF(r proxy.Request) string {
+	host := r.Client
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	size := "-"
+	if r.Size > 0 {
+		size = strconv.FormatInt(r.Size, 10)
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s`,
+		host, r.Start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, r.URI, r.Proto, r.Status, size,
+	)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/zhsj/wghttp/internal/proxy"
+)
+
+func TestFormatCLF(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 8*3600))
+	for _, tc := range []struct {
+		r    proxy.Request
+		want string
+	}{
+		{
+			proxy.Request{Client: "127.0.0.1:35310", Start: start, Method: "GET", URI: "http://example.com/", Proto: "HTTP/1.1", Status: 200, Size: 1256},
+			`127.0.0.1 - - [02/Jan/2024:03:04:05 +0800] "GET http://example.com/ HTTP/1.1" 200 1256`,
+		},
+		{
+			proxy.Request{Client: "[::1]:35310", Start: start.UTC(), Method: "CONNECT", URI: "example.com:443", Proto: "HTTP/1.1", Status: 502},
+			`::1 - - [01/Jan/2024:19:04:05 +0000] "CONNECT example.com:443 HTTP/1.1" 502 -`,
+		},
+		{
+			proxy.Request{Client: "pipe", Start: start, Method: "GET", URI: "/", Proto: "HTTP/1.0", Status: 405, Size: 19},
+			`pipe - - [02/Jan/2024:03:04:05 +0800] "GET / HTTP/1.0" 405 19`,
+		},
+	} {
+		if got := formatCLF(tc.r); got != tc.want {
+			t.Errorf("formatCLF() = %s, want %s", got, tc.want)
+		}
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	var buf bytes.Buffer
+	l := &accessLog{w: &buf, format: "combined"}
+	l.log(proxy.Request{
+		Client: "127.0.0.1:35310", Start: time.Unix(0, 0).UTC(),
+		Method: "GET", URI: "http://example.com/", Proto: "HTTP/1.1", Status: 200,
+		UserAgent: `curl/7.88.1 "quoted"`,
+	})
+	want := `127.0.0.1 - - [01/Jan/1970:00:00:00 +0000] "GET http://example.com/ HTTP/1.1" 200 - "-" "curl/7.88.1 \"quoted\""` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("combined log = %s, want %s", got, want)
+	}
+}
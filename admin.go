@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhsj/wghttp/internal/proxy"
+)
+
+const stageReady = "ready"
+
+// adminServer serves the admin endpoints of a tunnel from the start, so
+// that setting up the tunnel can be observed, e.g. when bringing up the
+// device hangs. Until the proxy is served, only stats and health check
+// are available, which report the stage of setup.
+type adminServer struct {
+	addr    net.Addr
+	handler atomic.Pointer[http.Handler]
+}
+
+// listenAdmin binds the admin server address and serves the endpoints
+// for setup. It returns nil if the admin server is disabled.
+func (t *tunnel) listenAdmin() *adminServer {
+	ln, err := net.Listen("tcp", t.opts.AdminListen)
+	if err != nil {
+		t.logger.Errorf("Create admin listener: %v", err)
+		if t.opts.AdminBindFailure == "fatal" {
+			os.Exit(1)
+		}
+		t.logger.Errorf("Admin server is disabled")
+		return nil
+	}
+	t.logger.Verbosef("Admin server listening on %s", ln.Addr())
+
+	a := &adminServer{addr: ln.Addr()}
+	setup := proxy.Proxy{
+		Stats:      t.setupStats,
+		HealthPath: t.opts.HealthPath,
+		HealthBody: t.opts.HealthBody,
+		Health: func() error {
+			return errors.New("starting: " + t.currentStage())
+		},
+	}
+	a.serve(setup.AdminHandler())
+	go func() {
+		err := http.Serve(ln, a)
+		t.logger.Errorf("Admin server: %v", err)
+	}()
+	return a
+}
+
+// serve replaces the handler of admin endpoints.
+func (a *adminServer) serve(h http.Handler) {
+	a.handler.Store(&h)
+}
+
+func (a *adminServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	(*a.handler.Load()).ServeHTTP(rw, r)
+}
+
+// setupStats returns the stats of tunnel before the proxy is served.
+func (t *tunnel) setupStats() (any, error) {
+	return struct {
+		Tunnel string `json:",omitempty"`
+		Stage  string
+
+		StartTimestamp int64
+		Uptime         int64
+
+		Verbose bool
+		Version string
+	}{
+		Tunnel: t.name,
+		Stage:  t.currentStage(),
+
+		StartTimestamp: t.start.Unix(),
+		Uptime:         int64(time.Since(t.start).Seconds()),
+
+		Verbose: t.verbose.Load(),
+		Version: version(),
+	}, nil
+}
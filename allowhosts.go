@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// allowedHosts returns the destination host patterns of --allow-host and
+// --allow-host-file.
+//
+// Each line of the file is a pattern. Empty lines and lines starting with #
+// are ignored.
+func (t *tunnel) allowedHosts() ([]string, error) {
+	patterns := []string{}
+	for _, pattern := range t.opts.AllowHosts {
+		if err := checkHostPattern(pattern); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	if t.opts.AllowHostFile == "" {
+		return patterns, nil
+	}
+
+	f, err := os.Open(t.opts.AllowHostFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := checkHostPattern(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", t.opts.AllowHostFile, n, err)
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no hosts in %s, which would deny every destination", t.opts.AllowHostFile)
+	}
+	return patterns, nil
+}
+
+// checkHostPattern checks that wildcard is only used as the first label.
+func checkHostPattern(pattern string) error {
+	if pattern == "" || strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+		return fmt.Errorf("invalid host pattern %q, wildcard is only allowed like *.example.com", pattern)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// printBanner prints a summary of the running tunnel. Keys are left out.
+func (t *tunnel) printBanner(dev *device.Device, listenAddr, adminAddr net.Addr) {
+	endpoint := t.opts.PeerEndpoint.String()
+	if status, err := readPeerStatus(dev); err == nil && status.Endpoint != "" && status.Endpoint != endpoint {
+		endpoint = fmt.Sprintf("%s (%s)", endpoint, status.Endpoint)
+	}
+	clientIPs := []string{}
+	for _, ip := range t.opts.ClientIPs {
+		clientIPs = append(clientIPs, ip.String())
+	}
+	dns := t.opts.DNS
+	if dns == "" {
+		dns = "system"
+	}
+
+	lines := []string{
+		fmt.Sprintf("wghttp %s", version()),
+		fmt.Sprintf("  Exit mode:   %s", t.opts.ExitMode),
+		fmt.Sprintf("  Listen:      %s", listenAddr),
+	}
+	if t.opts.Forward != "" {
+		lines = append(lines, fmt.Sprintf("  Forward:     %s", t.opts.Forward))
+	}
+	lines = append(lines,
+		fmt.Sprintf("  Client IPs:  %s", strings.Join(clientIPs, ", ")),
+		fmt.Sprintf("  Endpoint:    %s", endpoint),
+		fmt.Sprintf("  MTU:         %d", t.opts.MTU),
+		fmt.Sprintf("  DNS:         %s", dns),
+	)
+	if adminAddr != nil {
+		lines = append(lines, fmt.Sprintf("  Admin:       %s", adminAddr))
+	}
+
+	l := log.New(t.logOut, "INFO: "+t.logPrefix(), log.Ldate|log.Ltime)
+	for _, line := range lines {
+		l.Print(line)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"syscall"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// addrBind is a conn.Bind listening on a single local address, so packets
+// to peer are sent from it, and through the interface it's on.
+type addrBind struct {
+	addr netip.Addr
+
+	mu      sync.Mutex
+	udpConn *net.UDPConn
+}
+
+// newAddrBind returns a bind on addr, which must be an address of the host.
+func newAddrBind(addr netip.Addr) (*addrBind, error) {
+	ifAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("list interface addresses: %w", err)
+	}
+	for _, ifAddr := range ifAddrs {
+		if ipNet, ok := ifAddr.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(ipNet.IP); ok && ip.Unmap() == addr {
+				return &addrBind{addr: addr}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("bind address %s is not on any interface", addr)
+}
+
+func (b *addrBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.udpConn != nil {
+		return nil, 0, conn.ErrBindAlreadyOpen
+	}
+	udpConn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(b.addr, port)))
+	if err != nil {
+		return nil, 0, err
+	}
+	b.udpConn = udpConn
+	receive := func(buf []byte) (int, conn.Endpoint, error) {
+		n, addrPort, err := udpConn.ReadFromUDPAddrPort(buf)
+		return n, conn.StdNetEndpoint(netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())), err
+	}
+	return []conn.ReceiveFunc{receive}, udpConn.LocalAddr().(*net.UDPAddr).AddrPort().Port(), nil
+}
+
+func (b *addrBind) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.udpConn == nil {
+		return nil
+	}
+	err := b.udpConn.Close()
+	b.udpConn = nil
+	return err
+}
+
+// SetMark is only called if fwmark is configured, which isn't supported.
+func (b *addrBind) SetMark(mark uint32) error {
+	return errors.New("fwmark is not supported with bind address")
+}
+
+func (b *addrBind) Send(buf []byte, ep conn.Endpoint) error {
+	stdEP, ok := ep.(conn.StdNetEndpoint)
+	if !ok {
+		return conn.ErrWrongEndpointType
+	}
+	addrPort := netip.AddrPort(stdEP)
+	if addrPort.Addr().Is4() != b.addr.Is4() {
+		return syscall.EAFNOSUPPORT
+	}
+
+	b.mu.Lock()
+	udpConn := b.udpConn
+	b.mu.Unlock()
+	if udpConn == nil {
+		return net.ErrClosed
+	}
+	_, err := udpConn.WriteToUDPAddrPort(buf, addrPort)
+	return err
+}
+
+func (b *addrBind) ParseEndpoint(s string) (conn.Endpoint, error) {
+	addrPort, err := netip.ParseAddrPort(s)
+	return conn.StdNetEndpoint(addrPort), err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// linkTypeRaw is LINKTYPE_RAW of pcap, for packets starting with IPv4 or
+// IPv6 header.
+const linkTypeRaw = 101
+
+// captureTUN writes packets read from and written to the TUN device in
+// pcap format. It stops capturing after the file reaches maxSize.
+type captureTUN struct {
+	tun.Device
+	maxSize int64
+	onFull  func()
+
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+	full bool
+}
+
+func newCaptureTUN(dev tun.Device, path string, snapLen int, maxSize int64, onFull func()) (*captureTUN, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	c := &captureTUN{Device: dev, maxSize: maxSize, onFull: onFull, f: f, w: bufio.NewWriter(f)}
+
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(snapLen))
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeRaw)
+	if _, err := c.w.Write(hdr); err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.size = int64(len(hdr))
+	go c.flushEvery(time.Second)
+	atExit(func() { c.flushFile() })
+	return c, nil
+}
+
+func (c *captureTUN) Read(buf []byte, offset int) (int, error) {
+	n, err := c.Device.Read(buf, offset)
+	if n > 0 {
+		c.capture(buf[offset : offset+n])
+	}
+	return n, err
+}
+
+func (c *captureTUN) Write(buf []byte, offset int) (int, error) {
+	c.capture(buf[offset:])
+	return c.Device.Write(buf, offset)
+}
+
+func (c *captureTUN) capture(packet []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w == nil || c.full {
+		return
+	}
+	if c.size+16+int64(len(packet)) > c.maxSize {
+		c.full = true
+		_ = c.w.Flush()
+		if c.onFull != nil {
+			c.onFull()
+		}
+		return
+	}
+
+	now := time.Now()
+	hdr := make([]byte, 16)
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(packet)))
+	_, _ = c.w.Write(hdr)
+	_, _ = c.w.Write(packet)
+	c.size += int64(len(hdr) + len(packet))
+}
+
+// flushEvery flushes captured packets to the file, so that it can be
+// read while running.
+func (c *captureTUN) flushEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !c.flushFile() {
+			return
+		}
+	}
+}
+
+// flushFile writes captured packets to the file, and returns false if it's
+// closed. tun.Device has its own Flush, so it's named differently.
+func (c *captureTUN) flushFile() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w == nil {
+		return false
+	}
+	_ = c.w.Flush()
+	return true
+}
+
+func (c *captureTUN) Close() error {
+	err := c.Device.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w != nil {
+		if ferr := c.w.Flush(); ferr != nil && err == nil {
+			err = fmt.Errorf("flush capture: %w", ferr)
+		}
+		c.f.Close()
+		c.w = nil
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCaptureTUN(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wg.pcap")
+	fake := &fakeTUN{reads: []error{nil, nil}}
+	// The header and two records of 6 bytes packets fit in the file.
+	maxSize := int64(24 + 2*(16+6))
+	full := 0
+	c, err := newCaptureTUN(fake, path, 1420, maxSize, func() { full++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	if _, err := c.Read(buf, 4); err != nil {
+		t.Fatal(err)
+	}
+	copy(buf[4:], "sent!!")
+	if _, err := c.Write(buf[:10], 4); err != nil {
+		t.Fatal(err)
+	}
+	if full != 0 {
+		t.Errorf("onFull is called %d times before file is full", full)
+	}
+	// The file is full after the second packet.
+	if _, err := c.Read(buf, 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(buf[:10], 4); err != nil {
+		t.Fatal(err)
+	}
+	if full != 1 {
+		t.Errorf("onFull is called %d times after file is full, want 1", full)
+	}
+	if fake.written != 2 {
+		t.Errorf("%d packets written to device, want 2", fake.written)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !fake.closed {
+		t.Error("device isn't closed")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != maxSize {
+		t.Fatalf("capture file is %d bytes, want %d", len(data), maxSize)
+	}
+	le := binary.LittleEndian
+	if magic, major, minor, snapLen, linkType := le.Uint32(data[0:]), le.Uint16(data[4:]), le.Uint16(data[6:]), le.Uint32(data[16:]), le.Uint32(data[20:]); magic != 0xa1b2c3d4 || major != 2 || minor != 4 || snapLen != 1420 || linkType != linkTypeRaw {
+		t.Errorf("header = %x, want pcap 2.4 with snaplen 1420 and LINKTYPE_RAW", data[:24])
+	}
+	records := data[24:]
+	for _, want := range []string{"packet", "sent!!"} {
+		inclLen, origLen := le.Uint32(records[8:]), le.Uint32(records[12:])
+		if inclLen != 6 || origLen != 6 || string(records[16:16+inclLen]) != want {
+			t.Errorf("record = %x, want %q", records[:16+inclLen], want)
+		}
+		records = records[16+inclLen:]
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/netip"
+	"sync/atomic"
 	"time"
 
 	"github.com/zhsj/wghttp/internal/resolver"
@@ -13,6 +15,10 @@ import (
 
 type peer struct {
 	resolver *resolver.Resolver
+	logger   *device.Logger
+
+	keepalive  timeT
+	allowedIPs []netip.Prefix
 
 	pubKey keyT
 	psk    keyT
@@ -20,34 +26,87 @@ type peer struct {
 	host string
 	ip   netip.Addr
 	port uint16
+	// bindAddress is the local address packets are sent from, if set.
+	bindAddress netip.Addr
+
+	// candidates are the available addresses of host, including ip. If
+	// failover is enabled, ip is switched to the next one when the peer
+	// doesn't respond, as judged by the change from lastStatus.
+	candidates []netip.Addr
+	failover   bool
+	lastStatus peerStatus
+
+	// stats counts resolving host periodically. On failure, the current
+	// ip is kept, and only failures 1, 2, 4, 8... in a row are logged.
+	stats *resolveStats
+}
+
+// resolveStats counts periodic resolving of peer endpoint.
+type resolveStats struct {
+	Lookups  atomic.Int64
+	Failures atomic.Int64
+	// ConsecutiveFailures is reset to 0 by a successful lookup.
+	ConsecutiveFailures  atomic.Int64
+	LastSuccessTimestamp atomic.Int64
+}
+
+func (s *resolveStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Lookups              int64
+		Failures             int64
+		ConsecutiveFailures  int64
+		LastSuccessTimestamp int64
+	}{s.Lookups.Load(), s.Failures.Load(), s.ConsecutiveFailures.Load(), s.LastSuccessTimestamp.Load()})
 }
 
-func newPeerEndpoint() (*peer, error) {
+const (
+	// failoverInterval is how often the peer is checked for failover.
+	failoverInterval = 30 * time.Second
+	// failoverAfter is how long the peer has no handshake before failover,
+	// which is longer than the interval of rekey.
+	failoverAfter = 3 * time.Minute
+)
+
+func (t *tunnel) newPeerEndpoint() (*peer, error) {
 	p := &peer{
-		pubKey: opts.PeerKey,
-		psk:    opts.PresharedKey,
-		host:   opts.PeerEndpoint.host,
-		port:   opts.PeerEndpoint.port,
+		logger:     t.logger,
+		keepalive:  t.opts.KeepaliveInterval,
+		allowedIPs: t.opts.AllowedIPs,
+		pubKey:     t.opts.PeerKey,
+		psk:        t.opts.PresharedKey,
+		host:       t.opts.PeerEndpoint.host,
+		port:       t.opts.PeerEndpoint.port,
+		failover:   t.opts.PeerFailover,
+		stats:      &t.resolveStats,
+
+		bindAddress: netip.Addr(t.opts.BindAddress),
 	}
 	var err error
 	p.ip, err = netip.ParseAddr(p.host)
 	if err == nil {
+		if p.bindAddress.IsValid() && p.ip.Unmap().Is4() != p.bindAddress.Is4() {
+			return nil, fmt.Errorf("peer endpoint %s can't be reached from bind address %s", p.ip, p.bindAddress)
+		}
 		return p, nil
 	}
 
 	p.resolver = resolver.New(
-		opts.ResolveDNS,
+		t.opts.ResolveDNS,
 		func(ctx context.Context, network, address string) (net.Conn, error) {
 			netConn, err := (&net.Dialer{}).DialContext(ctx, network, address)
-			logger.Verbosef("Using %s to resolve peer endpoint: %v", opts.ResolveDNS, err)
+			t.logger.Verbosef("Using %s to resolve peer endpoint: %v", t.opts.ResolveDNS, err)
 			return netConn, err
 		},
 	)
+	p.resolver.SetTLS(uint16(t.opts.TLSMinVersion), t.opts.tlsCipherSuites())
 
-	p.ip, err = p.resolveHost()
+	p.candidates, err = p.resolveHost()
 	if err != nil {
 		return nil, fmt.Errorf("resolve peer endpoint ip: %w", err)
 	}
+	p.ip = p.candidates[0]
+	p.stats.LastSuccessTimestamp.Store(time.Now().Unix())
+	p.logger.Verbosef("PeerEndpoint candidates of %s: %v", p.host, p.candidates)
 
 	return p, err
 }
@@ -55,11 +114,12 @@ func newPeerEndpoint() (*peer, error) {
 func (p *peer) initConf() string {
 	conf := fmt.Sprintf("public_key=%s\n", p.pubKey)
 	conf += fmt.Sprintf("endpoint=%s\n", netip.AddrPortFrom(p.ip, p.port))
-	conf += "allowed_ip=0.0.0.0/0\n"
-	conf += "allowed_ip=::/0\n"
+	for _, prefix := range p.allowedIPs {
+		conf += fmt.Sprintf("allowed_ip=%s\n", prefix)
+	}
 
-	if opts.KeepaliveInterval > 0 {
-		conf += fmt.Sprintf("persistent_keepalive_interval=%d\n", opts.KeepaliveInterval)
+	if p.keepalive > 0 {
+		conf += fmt.Sprintf("persistent_keepalive_interval=%d\n", p.keepalive)
 	}
 	if p.psk != "" {
 		conf += fmt.Sprintf("preshared_key=%s\n", p.psk)
@@ -69,54 +129,112 @@ func (p *peer) initConf() string {
 }
 
 func (p *peer) updateConf() (string, bool) {
-	newIP, err := p.resolveHost()
+	p.stats.Lookups.Add(1)
+	candidates, err := p.resolveHost()
 	if err != nil {
-		logger.Verbosef("Resolve peer endpoint: %v", err)
+		p.stats.Failures.Add(1)
+		n := p.stats.ConsecutiveFailures.Add(1)
+		if n&(n-1) == 0 {
+			p.logger.Errorf("Resolve peer endpoint (%d failures in a row), keep using %s: %v", n, p.ip, err)
+		} else {
+			p.logger.Verbosef("Resolve peer endpoint: %v", err)
+		}
 		return "", false
 	}
+	if n := p.stats.ConsecutiveFailures.Swap(0); n > 0 {
+		p.logger.Errorf("Resolve peer endpoint succeeded after %d failures", n)
+	}
+	p.stats.LastSuccessTimestamp.Store(time.Now().Unix())
+	p.candidates = candidates
+	newIP := candidates[0]
+	if p.failover {
+		// Keep the current one if it's still available, which may be
+		// switched to by failover.
+		for _, ip := range candidates {
+			if ip == p.ip {
+				newIP = ip
+			}
+		}
+	}
 	if p.ip == newIP {
 		return "", false
 	}
 	p.ip = newIP
-	logger.Verbosef("PeerEndpoint is changed to: %s", p.ip)
+	p.logger.Verbosef("PeerEndpoint is changed to: %s", p.ip)
+	return p.endpointConf(), true
+}
+
+// failoverConf switches to the next candidate if the peer doesn't respond:
+// there's no recent handshake, and packets are sent but nothing is
+// received since last check.
+func (p *peer) failoverConf(status peerStatus) (string, bool) {
+	last := p.lastStatus
+	p.lastStatus = status
+	if len(p.candidates) < 2 ||
+		time.Since(time.Unix(status.LastHandshakeTimestamp, 0)) < failoverAfter ||
+		status.SentBytes == last.SentBytes ||
+		status.ReceivedBytes != last.ReceivedBytes {
+		return "", false
+	}
 
+	next := p.candidates[0]
+	for i, ip := range p.candidates {
+		if ip == p.ip {
+			next = p.candidates[(i+1)%len(p.candidates)]
+		}
+	}
+	p.logger.Errorf("PeerEndpoint %s doesn't respond, failing over to %s", p.ip, next)
+	p.ip = next
+	return p.endpointConf(), true
+}
+
+func (p *peer) endpointConf() string {
 	conf := fmt.Sprintf("public_key=%s\n", p.pubKey)
 	conf += "update_only=true\n"
 	conf += fmt.Sprintf("endpoint=%s\n", netip.AddrPortFrom(p.ip, p.port))
-	return conf, true
+	return conf
 }
 
-func (p *peer) resolveHost() (netip.Addr, error) {
+// resolveHost returns the addresses of host which can be dialed.
+func (p *peer) resolveHost() ([]netip.Addr, error) {
 	ips, err := p.resolver.LookupNetIP(context.Background(), "ip", p.host)
 	if err != nil {
-		return netip.Addr{}, fmt.Errorf("resolve ip for %s: %w", p.host, err)
+		return nil, fmt.Errorf("resolve ip for %s: %w", p.host, err)
 	}
+	var available []netip.Addr
 	for _, ip := range ips {
 		// netstack doesn't seem to understand IPv4-mapped IPv6 addresses.
 		ip = ip.Unmap()
-		conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, p.port)))
+		var laddr *net.UDPAddr
+		if p.bindAddress.IsValid() {
+			laddr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(p.bindAddress, 0))
+		}
+		conn, err := net.DialUDP("udp", laddr, net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, p.port)))
 		if err == nil {
 			conn.Close()
-			return ip, nil
+			available = append(available, ip)
 		} else {
-			logger.Verbosef("Dial %s: %s", ip, err)
+			p.logger.Verbosef("Dial %s: %s", ip, err)
 		}
 	}
-	return netip.Addr{}, fmt.Errorf("no available ip for %s", p.host)
+	if len(available) == 0 {
+		return nil, fmt.Errorf("no available ip for %s", p.host)
+	}
+	return available, nil
 }
 
-func ipcSet(dev *device.Device) error {
-	conf := fmt.Sprintf("private_key=%s\n", opts.PrivateKey)
-	if opts.ClientPort != 0 {
-		conf += fmt.Sprintf("listen_port=%d\n", opts.ClientPort)
+func (t *tunnel) ipcSet(dev *device.Device) error {
+	conf := fmt.Sprintf("private_key=%s\n", t.opts.PrivateKey)
+	if t.opts.ClientPort != 0 {
+		conf += fmt.Sprintf("listen_port=%d\n", t.opts.ClientPort)
 	}
 
-	peer, err := newPeerEndpoint()
+	peer, err := t.newPeerEndpoint()
 	if err != nil {
 		return err
 	}
 	conf += peer.initConf()
-	logger.Verbosef("Device config:\n%s", conf)
+	t.logger.Verbosef("Device config:\n%s", conf)
 
 	if err := dev.IpcSet(conf); err != nil {
 		return err
@@ -124,16 +242,36 @@ func ipcSet(dev *device.Device) error {
 
 	if peer.resolver != nil {
 		go func() {
-			c := time.Tick(time.Duration(opts.ResolveInterval) * time.Second)
+			resolveTick := time.Tick(time.Duration(t.opts.ResolveInterval) * time.Second)
+			var failoverTick <-chan time.Time
+			if peer.failover {
+				failoverTick = time.Tick(failoverInterval)
+			}
 
-			for range c {
-				conf, needUpdate := peer.updateConf()
+			for {
+				var conf string
+				var needUpdate bool
+				select {
+				case <-dev.Wait():
+					// The device is closed. --tun-error=rebuild
+					// only replaces netstack under the same
+					// device, so it keeps this loop running.
+					return
+				case <-resolveTick:
+					conf, needUpdate = peer.updateConf()
+				case <-failoverTick:
+					status, err := readPeerStatus(dev)
+					if err != nil {
+						continue
+					}
+					conf, needUpdate = peer.failoverConf(status)
+				}
 				if !needUpdate {
 					continue
 				}
 
 				if err := dev.IpcSet(conf); err != nil {
-					logger.Errorf("Config device: %v", err)
+					t.logger.Errorf("Config device: %v", err)
 				}
 			}
 		}()
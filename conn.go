@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/base64"
+	"net/netip"
 
 	"golang.zx2c4.com/wireguard/conn"
 )
@@ -12,17 +13,24 @@ type connBind struct {
 	defaultBind conn.Bind
 }
 
-func newConnBind(clientID string) conn.Bind {
+func (t *tunnel) newConnBind(clientID string) (conn.Bind, error) {
 	defaultBind := conn.NewDefaultBind()
+	if bindAddress := netip.Addr(t.opts.BindAddress); bindAddress.IsValid() {
+		var err error
+		defaultBind, err = newAddrBind(bindAddress)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if clientID == "" {
-		return defaultBind
+		return defaultBind, nil
 	}
 	parsed, err := base64.StdEncoding.DecodeString(clientID)
 	if err != nil {
-		logger.Errorf("Invalid client id: %v, fallback to default", err)
-		return defaultBind
+		t.logger.Errorf("Invalid client id: %v, fallback to default", err)
+		return defaultBind, nil
 	}
-	return &connBind{clientID: parsed, defaultBind: defaultBind}
+	return &connBind{clientID: parsed, defaultBind: defaultBind}, nil
 }
 
 func (c *connBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
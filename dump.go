@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// writeDump writes the snapshot as JSON lines. The first line summarizes
+// goroutines, and each following line is an active client connection.
+func writeDump(w io.Writer, tunnels []*tunnel) {
+	now := time.Now()
+	states := goroutineStates()
+	total := 0
+	for _, n := range states {
+		total += n
+	}
+	lines := []any{struct {
+		Time            time.Time
+		Goroutines      int
+		GoroutineStates map[string]int
+	}{now, total, states}}
+
+	for _, t := range tunnels {
+		counters := t.counters.Load()
+		if counters == nil {
+			continue
+		}
+		for _, r := range counters.Active() {
+			conn := struct {
+				Tunnel        string `json:",omitempty"`
+				Peer          string
+				ID            uint64
+				Protocol      string
+				Client        string
+				Host          string `json:",omitempty"`
+				Destination   string `json:",omitempty"`
+				Source        string `json:",omitempty"`
+				Start         time.Time
+				Age           float64
+				SentBytes     int64
+				ReceivedBytes int64
+			}{
+				Tunnel:        t.name,
+				Peer:          t.opts.PeerKey.base64(),
+				ID:            r.ID,
+				Protocol:      r.Protocol,
+				Client:        r.Client.String(),
+				Host:          r.Host,
+				Start:         r.Start,
+				Age:           now.Sub(r.Start).Seconds(),
+				SentBytes:     r.SentBytes,
+				ReceivedBytes: r.ReceivedBytes,
+			}
+			if r.Destination != nil {
+				conn.Destination = r.Destination.String()
+			}
+			if r.Source != nil {
+				conn.Source = r.Source.String()
+			}
+			lines = append(lines, conn)
+		}
+	}
+
+	var b strings.Builder
+	for _, line := range lines {
+		data, _ := json.Marshal(line)
+		b.Write(append(data, '\n'))
+	}
+	_, _ = io.WriteString(w, b.String())
+}
+
+// goroutineStates counts goroutines by state, like "IO wait" or "select".
+func goroutineStates() map[string]int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	states := map[string]int{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		// e.g. goroutine 7 [IO wait, 2 minutes]:
+		if !strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+		start, end := strings.IndexByte(line, '['), strings.LastIndexByte(line, ']')
+		if start < 0 || end < start {
+			continue
+		}
+		state, _, _ := strings.Cut(line[start+1:end], ",")
+		states[state]++
+	}
+	return states
+}
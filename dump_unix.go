@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// dumpOnQuit writes a snapshot of tunnels to stderr on SIGQUIT, before
+// Go's stack dump, e.g. to debug a stuck instance.
+func dumpOnQuit(tunnels []*tunnel) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGQUIT)
+	<-c
+	writeDump(os.Stderr, tunnels)
+	runExitHooks()
+	// Exit with stack dump as if it's not handled.
+	signal.Reset(syscall.SIGQUIT)
+	_ = syscall.Kill(os.Getpid(), syscall.SIGQUIT)
+}
@@ -0,0 +1,4 @@
+package main
+
+// dumpOnQuit does nothing, as there's no SIGQUIT on Windows.
+func dumpOnQuit(tunnels []*tunnel) {}
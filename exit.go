@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var exitHooks struct {
+	sync.Mutex
+	funcs  []func()
+	notify sync.Once
+}
+
+// atExit registers f to be called before exiting by exit, SIGINT or
+// SIGTERM, e.g. to flush buffered logs.
+func atExit(f func()) {
+	exitHooks.Lock()
+	exitHooks.funcs = append(exitHooks.funcs, f)
+	exitHooks.Unlock()
+
+	exitHooks.notify.Do(func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-c
+			runExitHooks()
+			reraise(sig)
+		}()
+	})
+}
+
+func runExitHooks() {
+	exitHooks.Lock()
+	defer exitHooks.Unlock()
+	for _, f := range exitHooks.funcs {
+		f()
+	}
+}
+
+// exit calls the functions registered by atExit, and exits with code.
+func exit(code int) {
+	runExitHooks()
+	os.Exit(code)
+}
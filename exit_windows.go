@@ -0,0 +1,8 @@
+package main
+
+import "os"
+
+// reraise exits, as signals can't be sent to a process on Windows.
+func reraise(sig os.Signal) {
+	os.Exit(1)
+}
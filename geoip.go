@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// geoIP annotates addresses with the ranges in a CSV file, which is loaded
+// on first lookup. Each record is a start address, an end address, and
+// columns describing the range, like the IP to Country and IP to ASN
+// databases of DB-IP in CSV format.
+type geoIP struct {
+	path string
+
+	once   sync.Once
+	ranges []geoRange
+	err    error
+}
+
+type geoRange struct {
+	start, end netip.Addr
+	info       string
+}
+
+func (g *geoIP) load() {
+	f, err := os.Open(g.path)
+	if err != nil {
+		g.err = err
+		return
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			g.err = err
+			return
+		}
+		if len(record) < 3 {
+			line, _ := r.FieldPos(0)
+			g.err = fmt.Errorf("%s:%d: expect start, end and description", g.path, line)
+			return
+		}
+		start, err1 := netip.ParseAddr(record[0])
+		end, err2 := netip.ParseAddr(record[1])
+		if err1 != nil || err2 != nil {
+			// Skip header.
+			continue
+		}
+		g.ranges = append(g.ranges, geoRange{start: start, end: end, info: strings.Join(record[2:], " ")})
+	}
+	sort.Slice(g.ranges, func(i, j int) bool { return g.ranges[i].start.Less(g.ranges[j].start) })
+}
+
+// lookup returns the description of the range containing ip, or empty if
+// there's none.
+func (g *geoIP) lookup(ip netip.Addr) (string, error) {
+	g.once.Do(g.load)
+	if g.err != nil {
+		return "", g.err
+	}
+	ip = ip.Unmap()
+	i := sort.Search(len(g.ranges), func(i int) bool { return ip.Less(g.ranges[i].start) })
+	if i == 0 {
+		return "", nil
+	}
+	if r := g.ranges[i-1]; r.start.BitLen() == ip.BitLen() && !r.end.Less(ip) {
+		return r.info, nil
+	}
+	return "", nil
+}
+
+// endpointGeo returns the descriptions of the address of endpoint in
+// --geoip files, separated by commas.
+func (t *tunnel) endpointGeo(endpoint string) string {
+	addrPort, err := netip.ParseAddrPort(endpoint)
+	if err != nil {
+		return ""
+	}
+	infos := []string{}
+	for _, g := range t.geoIPs {
+		info, err := g.lookup(addrPort.Addr())
+		if err != nil {
+			t.geoIPErr.Do(func() { t.logger.Errorf("Load GeoIP: %v", err) })
+			continue
+		}
+		if info != "" {
+			infos = append(infos, info)
+		}
+	}
+	return strings.Join(infos, ", ")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeoIPLookup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "geoip.csv")
+	csv := `start,end,country
+1.0.1.0,1.0.3.255,CN
+1.0.0.0,1.0.0.255,AU
+8.8.8.0,8.8.8.255,US,"Mountain View"
+2001:db8::,2001:db8::ffff,ZZ
+`
+	if err := os.WriteFile(file, []byte(csv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	g := &geoIP{path: file}
+
+	for _, tc := range []struct {
+		ip   string
+		want string
+	}{
+		{"1.0.0.0", "AU"},
+		{"1.0.0.255", "AU"},
+		{"1.0.1.0", "CN"},
+		{"1.0.2.1", "CN"},
+		{"1.0.3.255", "CN"},
+		{"::ffff:1.0.0.1", "AU"},
+		{"8.8.8.8", "US Mountain View"},
+		{"0.255.255.255", ""},
+		{"1.0.4.0", ""},
+		{"8.8.7.255", ""},
+		{"8.8.9.0", ""},
+		{"255.255.255.255", ""},
+		{"2001:db8::", "ZZ"},
+		{"2001:db8::ffff", "ZZ"},
+		{"2001:db8::1:0", ""},
+		// They come right after IPv4 ranges in order, but aren't in them.
+		{"::", ""},
+		{"::1", ""},
+	} {
+		got, err := g.lookup(netip.MustParseAddr(tc.ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("lookup(%s) = %q, want %q", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestGeoIPLoadError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "geoip.csv")
+	if err := os.WriteFile(file, []byte("1.0.0.0,1.0.0.255,AU\n1.0.1.0,1.0.1.255\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	g := &geoIP{path: file}
+	want := file + ":2: expect start, end and description"
+	if _, err := g.lookup(netip.MustParseAddr("1.0.0.1")); err == nil || err.Error() != want {
+		t.Errorf("lookup: error %v, want %q", err, want)
+	}
+
+	g = &geoIP{path: file + ".missing"}
+	if _, err := g.lookup(netip.MustParseAddr("1.0.0.1")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("lookup in missing file: error %v, want %v", err, fs.ErrNotExist)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+const (
+	// handshakePollInterval is how often the last handshake of peer is
+	// checked.
+	handshakePollInterval = time.Second
+	// handshakeWindow is the window of recent handshakes for rate.
+	handshakeWindow = 10 * time.Minute
+	// handshakeWarnInterval is how often the warning is repeated at most.
+	handshakeWarnInterval = 30 * time.Minute
+)
+
+// handshakeMonitor counts handshakes with peer, and warns if they happen
+// more often than minInterval. Normally, a new handshake happens every 2
+// minutes while there's traffic, so frequent ones indicate a rekeying
+// storm, e.g. two clients using the same key.
+type handshakeMonitor struct {
+	minInterval time.Duration
+	warn        func(format string, args ...any)
+
+	mu     sync.Mutex
+	last   int64
+	total  int64
+	recent []time.Time
+	warned time.Time
+}
+
+// observe checks timestamp, which is the last handshake time in seconds.
+func (m *handshakeMonitor) observe(timestamp int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if timestamp == 0 || timestamp == m.last {
+		return
+	}
+	last := m.last
+	m.last = timestamp
+	m.total++
+
+	now := time.Now()
+	recent := m.recent[:0]
+	for _, t := range m.recent {
+		if now.Sub(t) < handshakeWindow {
+			recent = append(recent, t)
+		}
+	}
+	m.recent = append(recent, now)
+
+	interval := time.Duration(timestamp-last) * time.Second
+	if last == 0 || m.minInterval <= 0 || interval >= m.minInterval {
+		return
+	}
+	if now.Sub(m.warned) > handshakeWarnInterval {
+		m.warned = now
+		m.warn(
+			"Handshake with peer %v after last one, more often than --handshake-min-interval=%v, "+
+				"%d handshakes in last %v. The connection may be unstable, or another client may use the same key",
+			interval, m.minInterval, len(m.recent), handshakeWindow,
+		)
+	}
+}
+
+// MarshalJSON reports the total handshakes, and the rate per hour of recent
+// ones.
+func (m *handshakeMonitor) MarshalJSON() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recent := 0
+	for _, t := range m.recent {
+		if time.Since(t) < handshakeWindow {
+			recent++
+		}
+	}
+	return json.Marshal(struct {
+		Total   int64
+		PerHour float64
+	}{m.total, float64(recent) * float64(time.Hour) / float64(handshakeWindow)})
+}
+
+// monitorHandshakes checks the last handshake of dev with m, until dev is
+// closed.
+func monitorHandshakes(dev *device.Device, m *handshakeMonitor) {
+	ticker := time.NewTicker(handshakePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dev.Wait():
+			return
+		case <-ticker.C:
+		}
+		status, err := readPeerStatus(dev)
+		if err != nil {
+			continue
+		}
+		m.observe(status.LastHandshakeTimestamp)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+func TestHandshakeMonitor(t *testing.T) {
+	var warnings []string
+	m := &handshakeMonitor{
+		minInterval: time.Minute,
+		warn: func(format string, args ...any) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		},
+	}
+
+	for _, tc := range []struct {
+		timestamp int64
+		warnings  int
+	}{
+		// No handshake yet.
+		{0, 0},
+		// The first handshake has no interval.
+		{1000, 0},
+		// The same handshake is polled again.
+		{1000, 0},
+		// At the threshold.
+		{1060, 0},
+		// Under the threshold.
+		{1090, 1},
+		// The warning isn't repeated soon.
+		{1100, 1},
+		{1300, 1},
+	} {
+		m.observe(tc.timestamp)
+		if len(warnings) != tc.warnings {
+			t.Fatalf("after handshake at %d, %d warnings, want %d: %q", tc.timestamp, len(warnings), tc.warnings, warnings)
+		}
+	}
+	if !strings.Contains(warnings[0], "Handshake with peer 30s after last one") {
+		t.Errorf("warning = %q, want interval 30s", warnings[0])
+	}
+	if m.total != 5 || len(m.recent) != 5 {
+		t.Errorf("%d handshakes, %d recent ones, want 5", m.total, len(m.recent))
+	}
+
+	// It only counts without --handshake-min-interval.
+	m = &handshakeMonitor{warn: func(string, ...any) { t.Error("warned without min interval") }}
+	m.observe(1000)
+	m.observe(1001)
+}
+
+func TestMonitorHandshakesStops(t *testing.T) {
+	tunDev, _, err := netstack.CreateNetTUN([]netip.Addr{netip.MustParseAddr("10.0.0.2")}, nil, 1420)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+
+	done := make(chan struct{})
+	go func() {
+		monitorHandshakes(dev, &handshakeMonitor{})
+		close(done)
+	}()
+	dev.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("monitor doesn't stop after device is closed")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pushInflux pushes stats to url in InfluxDB line protocol every interval.
+func (t *tunnel) pushInflux(url, token string, interval time.Duration, stats func() (any, error)) {
+	client := &http.Client{Timeout: interval}
+	for range time.Tick(interval) {
+		s, err := stats()
+		if err != nil {
+			continue
+		}
+		line, err := influxLine("wghttp", s, time.Now())
+		if err != nil {
+			t.logger.Verbosef("Encode stats for InfluxDB: %v", err)
+			continue
+		}
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(line))
+		if err != nil {
+			t.logger.Errorf("Push stats to InfluxDB: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.logger.Verbosef("Push stats to InfluxDB: %v", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			t.logger.Verbosef("Push stats to InfluxDB: %s", resp.Status)
+		}
+	}
+}
+
+// influxLine encodes stats as a line of measurement. Nested keys are joined
+// with ".", numbers are fields, and strings are tags. Fields are always
+// floats, as the JSON of a number doesn't tell its type, e.g. a float is
+// encoded as 0 when it's zero, and InfluxDB rejects a field changing type.
+func influxLine(measurement string, stats any, t time.Time) (string, error) {
+	tags, fields, err := flattenStats(stats)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(influxEscape(measurement, ", "))
+	for _, k := range sortedKeys(tags) {
+		fmt.Fprintf(&b, ",%s=%s", influxEscape(k, ",= "), influxEscape(tags[k], ",= "))
+	}
+	for i, k := range sortedKeys(fields) {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		fmt.Fprintf(&b, "%s%s=%s", sep, influxEscape(k, ",= "), fields[k])
+	}
+	fmt.Fprintf(&b, " %d\n", t.UnixNano())
+	return b.String(), nil
+}
+
+// flattenStats flattens stats in JSON to tags of strings and fields of
+// numbers. Nested keys are joined with ".".
+func flattenStats(stats any) (map[string]string, map[string]json.Number, error) {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return nil, nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, nil, err
+	}
+
+	tags, fields := map[string]string{}, map[string]json.Number{}
+	flatten("", v, tags, fields)
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("no fields in stats")
+	}
+	return tags, fields, nil
+}
+
+func flatten(prefix string, v any, tags map[string]string, fields map[string]json.Number) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			flatten(k, child, tags, fields)
+		}
+	case json.Number:
+		fields[prefix] = v
+	case string:
+		if v != "" {
+			tags[prefix] = v
+		}
+	}
+}
+
+func influxEscape(s, chars string) string {
+	for _, c := range chars {
+		s = strings.ReplaceAll(s, string(c), `\`+string(c))
+	}
+	return s
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInfluxLine(t *testing.T) {
+	type bandwidth struct {
+		Sent float64
+	}
+	stats := struct {
+		Version   string
+		Endpoint  string
+		Empty     string
+		SentBytes int64
+		Bandwidth bandwidth
+		Peer      map[string]any
+		Bool      bool
+	}{
+		Version:   "v1 dev",
+		Endpoint:  "a,b=c",
+		SentBytes: 1024,
+		Bandwidth: bandwidth{Sent: 0},
+		Peer:      map[string]any{"Rate": 1.5},
+		Bool:      true,
+	}
+
+	got, err := influxLine("wg http", stats, time.Unix(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `wg\ http,Endpoint=a\,b\=c,Version=v1\ dev Bandwidth.Sent=0,Peer.Rate=1.5,SentBytes=1024 1000000002` + "\n"
+	if got != want {
+		t.Errorf("influxLine() = %q, want %q", got, want)
+	}
+
+	if _, err := influxLine("wghttp", struct{ Version string }{"v1"}, time.Now()); err == nil {
+		t.Error("influxLine() without fields succeeds, want error")
+	}
+}
@@ -0,0 +1,155 @@
+// Package ipfix exports flow records to an IPFIX (RFC 7011) collector over
+// UDP.
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Information Elements, see https://www.iana.org/assignments/ipfix.
+const (
+	ieOctetDeltaCount          = 1
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+)
+
+const (
+	version          = 10
+	templateSetID    = 2
+	observationID    = 1
+	protocolTCP      = 6
+	firstTemplateID  = 256
+	messageHeaderLen = 16
+	setHeaderLen     = 4
+)
+
+// Flow is an unidirectional TCP flow.
+type Flow struct {
+	Src   netip.AddrPort
+	Dst   netip.AddrPort
+	Bytes uint64
+	Start time.Time
+	End   time.Time
+}
+
+// templateID returns the template for flow, based on its address families.
+func (f Flow) templateID() uint16 {
+	id := uint16(firstTemplateID)
+	if f.Src.Addr().Is6() {
+		id += 2
+	}
+	if f.Dst.Addr().Is6() {
+		id++
+	}
+	return id
+}
+
+type Exporter struct {
+	conn net.Conn
+
+	mu  sync.Mutex
+	seq uint32
+}
+
+func NewExporter(collector string) (*Exporter, error) {
+	conn, err := net.Dial("udp", collector)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{conn: conn}, nil
+}
+
+// Export sends flows in one message. Templates are sent with every message,
+// as required for UDP transport.
+func (e *Exporter) Export(flows ...Flow) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	msg := make([]byte, messageHeaderLen)
+	msg = appendTemplates(msg)
+	for _, f := range flows {
+		msg = appendData(msg, f)
+	}
+
+	binary.BigEndian.PutUint16(msg[0:], version)
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+	binary.BigEndian.PutUint32(msg[4:], uint32(time.Now().Unix()))
+	binary.BigEndian.PutUint32(msg[8:], e.seq)
+	binary.BigEndian.PutUint32(msg[12:], observationID)
+
+	_, err := e.conn.Write(msg)
+	if err == nil {
+		e.seq += uint32(len(flows))
+	}
+	return err
+}
+
+func (e *Exporter) Close() error {
+	return e.conn.Close()
+}
+
+func appendTemplates(b []byte) []byte {
+	start := len(b)
+	b = binary.BigEndian.AppendUint16(b, templateSetID)
+	b = binary.BigEndian.AppendUint16(b, 0)
+
+	for id := uint16(firstTemplateID); id < firstTemplateID+4; id++ {
+		srcIE, srcLen := uint16(ieSourceIPv4Address), uint16(4)
+		if (id-firstTemplateID)&2 != 0 {
+			srcIE, srcLen = ieSourceIPv6Address, 16
+		}
+		dstIE, dstLen := uint16(ieDestinationIPv4Address), uint16(4)
+		if (id-firstTemplateID)&1 != 0 {
+			dstIE, dstLen = ieDestinationIPv6Address, 16
+		}
+
+		fields := [][2]uint16{
+			{srcIE, srcLen},
+			{dstIE, dstLen},
+			{ieSourceTransportPort, 2},
+			{ieDestinationTransportPort, 2},
+			{ieProtocolIdentifier, 1},
+			{ieOctetDeltaCount, 8},
+			{ieFlowStartMilliseconds, 8},
+			{ieFlowEndMilliseconds, 8},
+		}
+		b = binary.BigEndian.AppendUint16(b, id)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+		for _, f := range fields {
+			b = binary.BigEndian.AppendUint16(b, f[0])
+			b = binary.BigEndian.AppendUint16(b, f[1])
+		}
+	}
+
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	return b
+}
+
+func appendData(b []byte, f Flow) []byte {
+	start := len(b)
+	b = binary.BigEndian.AppendUint16(b, f.templateID())
+	b = binary.BigEndian.AppendUint16(b, 0)
+
+	b = append(b, f.Src.Addr().AsSlice()...)
+	b = append(b, f.Dst.Addr().AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, f.Src.Port())
+	b = binary.BigEndian.AppendUint16(b, f.Dst.Port())
+	b = append(b, protocolTCP)
+	b = binary.BigEndian.AppendUint64(b, f.Bytes)
+	b = binary.BigEndian.AppendUint64(b, uint64(f.Start.UnixMilli()))
+	b = binary.BigEndian.AppendUint64(b, uint64(f.End.UnixMilli()))
+
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	return b
+}
@@ -0,0 +1,131 @@
+package ipfix
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unhex decodes hex with spaces and newlines between bytes.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+const templateSet = `
+0002 0094
+0100 0008 0008 0004 000c 0004 0007 0002 000b 0002 0004 0001 0001 0008 0098 0008 0099 0008
+0101 0008 0008 0004 001c 0010 0007 0002 000b 0002 0004 0001 0001 0008 0098 0008 0099 0008
+0102 0008 001b 0010 000c 0004 0007 0002 000b 0002 0004 0001 0001 0008 0098 0008 0099 0008
+0103 0008 001b 0010 001c 0010 0007 0002 000b 0002 0004 0001 0001 0008 0098 0008 0099 0008
+`
+
+var (
+	flowStart = time.UnixMilli(1700000000000)
+	flowEnd   = time.UnixMilli(1700000001500)
+)
+
+func TestAppendTemplates(t *testing.T) {
+	got := appendTemplates(nil)
+	if want := unhex(t, templateSet); !bytes.Equal(got, want) {
+		t.Errorf("templates = %x, want %x", got, want)
+	}
+}
+
+func TestAppendData(t *testing.T) {
+	for _, tc := range []struct {
+		flow Flow
+		want string
+	}{
+		{
+			Flow{
+				Src:   netip.MustParseAddrPort("10.0.0.2:50000"),
+				Dst:   netip.MustParseAddrPort("93.184.216.34:443"),
+				Bytes: 1024,
+				Start: flowStart,
+				End:   flowEnd,
+			},
+			`0100 0029
+			0a000002 5db8d822 c350 01bb 06
+			0000000000000400 0000018bcfe56800 0000018bcfe56ddc`,
+		},
+		{
+			Flow{
+				Src:   netip.MustParseAddrPort("[fd00::2]:50000"),
+				Dst:   netip.MustParseAddrPort("[2606:2800:220:1:248:1893:25c8:1946]:443"),
+				Bytes: 1024,
+				Start: flowStart,
+				End:   flowEnd,
+			},
+			`0103 0041
+			fd000000000000000000000000000002 26062800022000010248189325c81946 c350 01bb 06
+			0000000000000400 0000018bcfe56800 0000018bcfe56ddc`,
+		},
+		{
+			Flow{
+				Src:   netip.MustParseAddrPort("[fd00::2]:50000"),
+				Dst:   netip.MustParseAddrPort("93.184.216.34:443"),
+				Start: flowStart,
+				End:   flowEnd,
+			},
+			`0102 0035
+			fd000000000000000000000000000002 5db8d822 c350 01bb 06
+			0000000000000000 0000018bcfe56800 0000018bcfe56ddc`,
+		},
+	} {
+		got := appendData(nil, tc.flow)
+		if want := unhex(t, tc.want); !bytes.Equal(got, want) {
+			t.Errorf("data of %s -> %s = %x, want %x", tc.flow.Src, tc.flow.Dst, got, want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+	e, err := NewExporter(collector.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	flow := Flow{
+		Src:   netip.MustParseAddrPort("10.0.0.2:50000"),
+		Dst:   netip.MustParseAddrPort("93.184.216.34:443"),
+		Start: flowStart,
+		End:   flowEnd,
+	}
+	buf := make([]byte, 1500)
+	for _, seq := range []string{"00000000", "00000002"} {
+		if err := e.Export(flow, flow); err != nil {
+			t.Fatal(err)
+		}
+		_ = collector.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := collector.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := buf[:n]
+		// Export time at msg[4:8] isn't checked.
+		want := unhex(t, "000a 00f6")
+		want = append(want, msg[4:8]...)
+		want = append(want, unhex(t, seq+"00000001")...)
+		want = append(want, unhex(t, templateSet)...)
+		want = appendData(want, flow)
+		want = appendData(want, flow)
+		if !bytes.Equal(msg, want) {
+			t.Errorf("message = %x, want %x", msg, want)
+		}
+	}
+}
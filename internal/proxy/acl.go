@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net"
+	"net/netip"
+)
+
+// aclListener only accepts connections from allowed source addresses.
+type aclListener struct {
+	net.Listener
+	allowed  []netip.Prefix
+	counters *Counters
+}
+
+func (l *aclListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.allow(c.RemoteAddr()) {
+			return c, nil
+		}
+		l.counters.denied.Add(1)
+		c.Close()
+	}
+}
+
+func (l *aclListener) allow(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcpAddr.AddrPort().Addr().Unmap()
+	for _, prefix := range l.allowed {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// familyListener only accepts connections from an address family, if
+// network is tcp4 or tcp6.
+type familyListener struct {
+	net.Listener
+	network  string
+	counters *Counters
+}
+
+func (l *familyListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if allowFamily(c.RemoteAddr(), l.network) {
+			return c, nil
+		}
+		l.counters.denied.Add(1)
+		c.Close()
+	}
+}
+
+// allowFamily reports whether addr is in the address family of network.
+// IPv4-mapped IPv6 addresses are treated as IPv4.
+func allowFamily(addr net.Addr, network string) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcpAddr.AddrPort().Addr().Unmap()
+	switch network {
+	case "tcp4":
+		return ip.Is4()
+	case "tcp6":
+		return ip.Is6()
+	}
+	return true
+}
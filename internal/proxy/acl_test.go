@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAllowFamily(t *testing.T) {
+	for _, tc := range []struct {
+		addr    string
+		network string
+		want    bool
+	}{
+		{"127.0.0.1:1234", "tcp", true},
+		{"[::1]:1234", "tcp", true},
+		{"127.0.0.1:1234", "tcp4", true},
+		{"[::1]:1234", "tcp4", false},
+		{"[::ffff:127.0.0.1]:1234", "tcp4", true},
+		{"127.0.0.1:1234", "tcp6", false},
+		{"[::1]:1234", "tcp6", true},
+		{"[::ffff:127.0.0.1]:1234", "tcp6", false},
+	} {
+		addr, err := net.ResolveTCPAddr("tcp", tc.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := allowFamily(addr, tc.network); got != tc.want {
+			t.Errorf("allowFamily(%s, %s) = %v, want %v", tc.addr, tc.network, got, tc.want)
+		}
+	}
+}
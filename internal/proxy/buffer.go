@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// relayBufferSize is the size of pooled buffers, the same as io.Copy.
+	relayBufferSize = 32 * 1024
+	// probeSize is the size read without a pooled buffer, so that idle
+	// connections don't hold one while waiting for data.
+	probeSize = 512
+)
+
+// copyFunc copies from src to dst until EOF, like io.Copy.
+type copyFunc func(dst io.Writer, src io.Reader) (int64, error)
+
+// buffers limits the total size of buffers used by relay loops. A relay
+// loop reads small chunks on its own, and checks out a pooled buffer only
+// while the reads are filled, i.e. there's more data pending. If all are
+// checked out, it goes on with small reads instead of waiting: the holder
+// may be blocked writing to a peer that waits on this loop.
+type buffers struct {
+	pool  sync.Pool
+	sem   chan struct{}
+	inUse atomic.Int64
+}
+
+func newBuffers(limit int) *buffers {
+	n := limit / relayBufferSize
+	if n < 1 {
+		n = 1
+	}
+	return &buffers{
+		pool: sync.Pool{New: func() any {
+			buf := make([]byte, relayBufferSize)
+			return &buf
+		}},
+		sem: make(chan struct{}, n),
+	}
+}
+
+// get returns a buffer, or nil if all are checked out.
+func (b *buffers) get() *[]byte {
+	select {
+	case b.sem <- struct{}{}:
+	default:
+		return nil
+	}
+	b.inUse.Add(1)
+	return b.pool.Get().(*[]byte)
+}
+
+func (b *buffers) put(buf *[]byte) {
+	b.pool.Put(buf)
+	b.inUse.Add(-1)
+	<-b.sem
+}
+
+// copy is like io.Copy, with buffers from b.
+func (b *buffers) copy(dst io.Writer, src io.Reader) (written int64, err error) {
+	probe := make([]byte, probeSize)
+	for {
+		var n int
+		n, err = copyChunk(dst, src, probe, &written)
+		if err == nil && n == len(probe) {
+			// More data is likely pending, so it's read with a pooled
+			// buffer until a read doesn't fill it.
+			if buf := b.get(); buf != nil {
+				for {
+					n, err = copyChunk(dst, src, *buf, &written)
+					if err != nil || n < len(*buf) {
+						break
+					}
+				}
+				b.put(buf)
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// copyChunk reads once from src into buf, writes the data read to dst, and
+// returns the size read.
+func copyChunk(dst io.Writer, src io.Reader, buf []byte, written *int64) (int, error) {
+	n, rerr := src.Read(buf)
+	if n > 0 {
+		nw, werr := dst.Write(buf[:n])
+		*written += int64(nw)
+		if werr != nil {
+			return n, werr
+		}
+		if nw != n {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, rerr
+}
+
+func (b *buffers) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Limit int64
+		InUse int64
+	}{int64(cap(b.sem)) * relayBufferSize, b.inUse.Load() * relayBufferSize})
+}
+
+// copy copies with relay buffers if they're limited.
+func (c *Counters) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if c.buffers == nil {
+		return io.Copy(dst, src)
+	}
+	return c.buffers.copy(dst, src)
+}
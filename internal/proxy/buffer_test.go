@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestBuffersCopy(t *testing.T) {
+	data := make([]byte, 3*relayBufferSize+probeSize+1)
+	rand.Read(data)
+
+	b := newBuffers(relayBufferSize)
+	for name, src := range map[string]func() io.Reader{
+		"full":     func() io.Reader { return bytes.NewReader(data) },
+		"one byte": func() io.Reader { return iotest.OneByteReader(bytes.NewReader(data)) },
+		"probe":    func() io.Reader { return io.LimitReader(bytes.NewReader(data), probeSize) },
+	} {
+		var dst bytes.Buffer
+		n, err := b.copy(&dst, src())
+		if err != nil {
+			t.Errorf("%s: copy: %v", name, err)
+		}
+		want := data
+		if name == "probe" {
+			want = data[:probeSize]
+		}
+		if n != int64(len(want)) || !bytes.Equal(dst.Bytes(), want) {
+			t.Errorf("%s: copied %d bytes, want %d", name, n, len(want))
+		}
+	}
+	if inUse := b.inUse.Load(); inUse != 0 {
+		t.Errorf("buffers in use = %d after copy, want 0", inUse)
+	}
+}
+
+func TestBuffersLimit(t *testing.T) {
+	b := newBuffers(relayBufferSize)
+
+	// The first copy holds the only buffer while waiting for more data
+	// after filled reads.
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = b.copy(io.Discard, pr)
+	}()
+	if _, err := pw.Write(make([]byte, probeSize+relayBufferSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf := b.get(); buf != nil {
+		t.Fatal("got buffer over limit")
+	}
+
+	pw.Close()
+	<-done
+	buf := b.get()
+	if buf == nil {
+		t.Fatal("buffer isn't released after copy")
+	}
+	b.put(buf)
+}
+
+// TestBuffersEcho relays both ways through an echo peer with one buffer, so
+// the loop holding it is blocked writing until the other loop makes progress.
+func TestBuffersEcho(t *testing.T) {
+	data := make([]byte, 8*relayBufferSize)
+	rand.Read(data)
+
+	b := newBuffers(relayBufferSize)
+	clientR, clientW := io.Pipe()
+	upR, upW := io.Pipe()
+	echoR, echoW := io.Pipe()
+	downR, downW := io.Pipe()
+	go func() {
+		_, _ = b.copy(upW, clientR)
+		upW.Close()
+	}()
+	go func() {
+		_, _ = io.Copy(echoW, upR)
+		echoW.Close()
+	}()
+	go func() {
+		_, _ = b.copy(downW, echoR)
+		downW.Close()
+	}()
+	go func() {
+		_, _ = clientW.Write(data)
+		clientW.Close()
+	}()
+
+	got := make(chan []byte)
+	go func() {
+		buf, _ := io.ReadAll(downR)
+		got <- buf
+	}()
+	select {
+	case buf := <-got:
+		if !bytes.Equal(buf, data) {
+			t.Errorf("echoed %d bytes, want %d", len(buf), len(data))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("relay is stuck, buffers in use = %d", b.inUse.Load())
+	}
+}
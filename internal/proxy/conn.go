@@ -0,0 +1,486 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type side int
+
+const (
+	sideClient side = iota
+	sideUpstream
+)
+
+var sideNames = [...]string{"client", "upstream"}
+
+type closeKind int
+
+const (
+	closeFIN closeKind = iota
+	closeReset
+)
+
+var closeKindNames = [...]string{"fin", "reset"}
+
+type protocol int
+
+const (
+	protocolHTTP protocol = iota
+	protocolConnect
+	protocolSOCKS5
+	protocolForward
+)
+
+var protocolNames = [...]string{"http", "connect", "socks5", "forward"}
+
+type closeReason int
+
+const (
+	reasonClient closeReason = iota
+	reasonUpstream
+	reasonDialError
+	reasonDrained
+	reasonIdle
+)
+
+// reasonNames are why client connections are closed. Connections denied by
+// AllowedSources are reported as denied with them.
+var reasonNames = [...]string{"client", "upstream", "dial_error", "drained", "idle"}
+
+// Counters counts events of proxied connections.
+type Counters struct {
+	// active is the number of client connections not closed yet.
+	active atomic.Int64
+	// denied is the number of client connections from disallowed sources.
+	denied atomic.Int64
+	// protocols is indexed by the protocol of client connections.
+	protocols [len(protocolNames)]struct {
+		total  atomic.Int64
+		active atomic.Int64
+	}
+	// closed is indexed by the side which ended the connection
+	// and how it was ended.
+	closed [len(sideNames)][len(closeKindNames)]atomic.Int64
+	// reasons is indexed by why client connections are closed.
+	reasons [len(reasonNames)]atomic.Int64
+
+	// bandwidth is set if the total throughput is limited.
+	bandwidth *bandwidth
+	// buffers is set if the total size of relay buffers is limited.
+	buffers *buffers
+	// dialTimeout is set if the dial timeout is adaptive.
+	dialTimeout *adaptiveTimeout
+
+	// conns is the set of active client connections.
+	conns sync.Map
+	// lastID is the ID of last accepted client connection.
+	lastID atomic.Uint64
+
+	// upstreamDialed and upstreamActive count upstream connections,
+	// including idle ones in the pool of HTTP proxy.
+	upstreamDialed atomic.Int64
+	upstreamActive atomic.Int64
+}
+
+func (c *Counters) MarshalJSON() ([]byte, error) {
+	closed := map[string]map[string]int64{}
+	for s := range c.closed {
+		closed[sideNames[s]] = map[string]int64{}
+		for k := range c.closed[s] {
+			closed[sideNames[s]][closeKindNames[k]] = c.closed[s][k].Load()
+		}
+	}
+	type protocolStats struct {
+		Total  int64
+		Active int64
+	}
+	protocols := map[string]protocolStats{}
+	var total int64
+	for p := range c.protocols {
+		protocols[protocolNames[p]] = protocolStats{
+			Total:  c.protocols[p].total.Load(),
+			Active: c.protocols[p].active.Load(),
+		}
+		total += protocols[protocolNames[p]].Total
+	}
+	reasons := map[string]int64{"denied": c.denied.Load()}
+	for r := range c.reasons {
+		reasons[reasonNames[r]] = c.reasons[r].Load()
+	}
+	type upstreamStats struct {
+		Dialed int64
+		Active int64
+	}
+	return json.Marshal(struct {
+		Total        int64
+		Active       int64
+		Denied       int64
+		Closed       map[string]map[string]int64
+		CloseReasons map[string]int64
+		Protocols    map[string]protocolStats
+		Upstream     upstreamStats
+		Bandwidth    *bandwidth       `json:",omitempty"`
+		Buffers      *buffers         `json:",omitempty"`
+		DialTimeout  *adaptiveTimeout `json:",omitempty"`
+	}{
+		total, c.active.Load(), c.denied.Load(), closed, reasons, protocols,
+		upstreamStats{c.upstreamDialed.Load(), c.upstreamActive.Load()},
+		c.bandwidth, c.buffers, c.dialTimeout,
+	})
+}
+
+// Active returns the records of active client connections in order of
+// accept. End and CloseReason are not set in them.
+func (c *Counters) Active() []Record {
+	records := []Record{}
+	c.conns.Range(func(key, _ any) bool {
+		r := key.(*trackedConn).record()
+		r.End, r.CloseReason = time.Time{}, ""
+		records = append(records, r)
+		return true
+	})
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records
+}
+
+func (c *Counters) open(p protocol) {
+	c.active.Add(1)
+	c.protocols[p].total.Add(1)
+	c.protocols[p].active.Add(1)
+}
+
+func (c *Counters) close(p protocol) {
+	c.active.Add(-1)
+	c.protocols[p].active.Add(-1)
+}
+
+// Record describes a finished client connection.
+type Record struct {
+	// ID is unique for client connections of the proxy, increasing in
+	// order of accept.
+	ID       uint64
+	Protocol string
+	Client   net.Addr
+	// Destination is the last upstream address dialed for the client, or
+	// reused from the pool of HTTP proxy, or nil if there's none.
+	Destination net.Addr
+	// Source is the local address of the last upstream connection, i.e.
+	// the client IP in WireGuard network in remote exit mode.
+	Source net.Addr
+	// Host is the last upstream host requested by the client, and
+	// DialDuration is the time spent dialing it, even if it failed, or
+	// zero if a pooled connection is reused.
+	Host         string
+	DialDuration time.Duration
+
+	Start time.Time
+	End   time.Time
+	// CloseReason is why the connection is closed: client, upstream,
+	// dial_error, drained or idle.
+	CloseReason string
+
+	// SentBytes is sent by client, ReceivedBytes is received by client.
+	SentBytes     int64
+	ReceivedBytes int64
+}
+
+// session is the state of a client connection, shared with the upstream
+// connections dialed for it through context.
+type session struct {
+	counters *Counters
+	id       uint64
+	start    time.Time
+
+	mu          sync.Mutex
+	protocol    protocol
+	destination net.Addr
+	source      net.Addr
+	// host is the last upstream host requested by client, which may be
+	// a domain.
+	host         string
+	dialDuration time.Duration
+	// dialFailed is whether the last dial failed, clientEnded is whether
+	// client closed or reset the connection, drained is whether it's
+	// closed by drain, and idle is whether it's closed by reapIdle. They
+	// decide the close reason.
+	dialFailed  bool
+	clientEnded bool
+	drained     bool
+	idle        bool
+
+	sent     atomic.Int64
+	received atomic.Int64
+	// lastActive is the Unix time in nanoseconds when client connection
+	// is accepted, or last read or written.
+	lastActive atomic.Int64
+}
+
+type sessionKey struct{}
+
+// setProtocol changes the protocol of session, which may be only known after
+// reading request.
+func (s *session) setProtocol(p protocol) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.protocol == p {
+		return
+	}
+	s.counters.protocols[s.protocol].total.Add(-1)
+	s.counters.close(s.protocol)
+	s.counters.open(p)
+	s.protocol = p
+}
+
+// classifyHTTP sets the protocol of session for CONNECT requests.
+func classifyHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if s, ok := r.Context().Value(sessionKey{}).(*session); ok && r.Method == http.MethodConnect {
+			s.setProtocol(protocolConnect)
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// traceReuse records the upstream of HTTP requests sent on a connection
+// reused from the pool, which may be dialed for another client, so that
+// it's not recorded by trackDial for the session of this one.
+func traceReuse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		s, ok := r.Context().Value(sessionKey{}).(*session)
+		if !ok || r.Method == http.MethodConnect {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if !info.Reused {
+					return
+				}
+				s.mu.Lock()
+				s.host = r.URL.Hostname()
+				s.destination = info.Conn.RemoteAddr()
+				s.source = info.Conn.LocalAddr()
+				s.dialDuration = 0
+				s.dialFailed = false
+				s.mu.Unlock()
+			},
+		}
+		next.ServeHTTP(rw, r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+	})
+}
+
+// connContext adds the session of client connection c to ctx.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*trackedConn); ok && tc.session != nil {
+		return context.WithValue(ctx, sessionKey{}, tc.session)
+	}
+	return ctx
+}
+
+type trackedListener struct {
+	net.Listener
+	protocol protocol
+	counters *Counters
+	onClose  func(Record)
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.counters.open(l.protocol)
+	tc := &trackedConn{
+		Conn:     c,
+		side:     sideClient,
+		counters: l.counters,
+		session:  &session{counters: l.counters, id: l.counters.lastID.Add(1), protocol: l.protocol, start: time.Now()},
+		onClose:  l.onClose,
+	}
+	tc.session.lastActive.Store(tc.session.start.UnixNano())
+	l.counters.conns.Store(tc, struct{}{})
+	return tc, nil
+}
+
+func trackDial(dial dialer, counters *Counters) dialer {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		start := time.Now()
+		c, err := dial(ctx, network, address)
+		if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+			host, _, _ := net.SplitHostPort(address)
+			s.mu.Lock()
+			s.host = host
+			s.dialDuration = time.Since(start)
+			s.dialFailed = err != nil
+			if err == nil {
+				s.destination = c.RemoteAddr()
+				s.source = c.LocalAddr()
+			}
+			s.mu.Unlock()
+		}
+		if err != nil {
+			return nil, err
+		}
+		counters.upstreamDialed.Add(1)
+		counters.upstreamActive.Add(1)
+		return &trackedConn{Conn: c, side: sideUpstream, counters: counters}, nil
+	}
+}
+
+// trackedConn records how the remote side ended the connection.
+type trackedConn struct {
+	net.Conn
+	side     side
+	counters *Counters
+
+	// session and onClose are only set for client side.
+	session *session
+	onClose func(Record)
+
+	once      sync.Once
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	bw := c.bandwidth()
+	if bw != nil {
+		b = b[:bw.readSize(len(b))]
+	}
+	n, err := c.Conn.Read(b)
+	if c.session != nil && n > 0 {
+		c.session.sent.Add(int64(n))
+		c.session.lastActive.Store(time.Now().UnixNano())
+	}
+	if bw != nil {
+		bw.wait(n)
+	}
+	if err != nil {
+		c.observe(err)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	if bw := c.bandwidth(); bw != nil {
+		bw.wait(len(b))
+	}
+	n, err := c.Conn.Write(b)
+	if c.session != nil && n > 0 {
+		c.session.received.Add(int64(n))
+		c.session.lastActive.Store(time.Now().UnixNano())
+	}
+	if err != nil {
+		c.observe(err)
+	}
+	return n, err
+}
+
+// bandwidth returns the limit of total throughput, which only applies to
+// client side.
+func (c *trackedConn) bandwidth() *bandwidth {
+	if c.side != sideClient {
+		return nil
+	}
+	return c.counters.bandwidth
+}
+
+func (c *trackedConn) Close() error {
+	if c.side == sideUpstream {
+		c.closeOnce.Do(func() { c.counters.upstreamActive.Add(-1) })
+	}
+	if c.side == sideClient {
+		c.closeOnce.Do(func() {
+			c.counters.conns.Delete(c)
+			c.session.mu.Lock()
+			c.counters.close(c.session.protocol)
+			c.counters.reasons[c.session.closeReason()].Add(1)
+			c.session.mu.Unlock()
+			if c.onClose != nil {
+				c.onClose(c.record())
+			}
+		})
+	}
+	return c.Conn.Close()
+}
+
+// CloseWrite half-closes the connection if it's supported, or closes it.
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+// closeReason decides why the connection is closed. Dial errors come
+// before client, since clients often give up after them.
+func (s *session) closeReason() closeReason {
+	switch {
+	case s.drained:
+		return reasonDrained
+	case s.idle:
+		return reasonIdle
+	case s.dialFailed:
+		return reasonDialError
+	case s.clientEnded:
+		return reasonClient
+	}
+	return reasonUpstream
+}
+
+func (c *trackedConn) record() Record {
+	s := c.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Record{
+		ID:            s.id,
+		Protocol:      protocolNames[s.protocol],
+		Client:        c.RemoteAddr(),
+		Destination:   s.destination,
+		Source:        s.source,
+		Host:          s.host,
+		DialDuration:  s.dialDuration,
+		Start:         s.start,
+		End:           time.Now(),
+		CloseReason:   reasonNames[s.closeReason()],
+		SentBytes:     s.sent.Load(),
+		ReceivedBytes: s.received.Load(),
+	}
+}
+
+func (c *trackedConn) observe(err error) {
+	var kind closeKind
+	switch {
+	case errors.Is(err, io.EOF):
+		kind = closeFIN
+	case isReset(err):
+		kind = closeReset
+	default:
+		return
+	}
+	c.once.Do(func() { c.counters.closed[c.side][kind].Add(1) })
+	if c.session != nil {
+		c.session.mu.Lock()
+		c.session.clientEnded = true
+		c.session.mu.Unlock()
+	}
+}
+
+func isReset(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	// netstack doesn't return syscall errors.
+	return strings.Contains(err.Error(), "connection reset by peer")
+}
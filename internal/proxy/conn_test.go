@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTrackedConnClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	counters := &Counters{}
+	tl := &trackedListener{Listener: ln, protocol: protocolSOCKS5, counters: counters}
+
+	for _, reset := range []bool{false, true} {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, err := tl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reset {
+			_ = client.(*net.TCPConn).SetLinger(0)
+		}
+		client.Close()
+		_, _ = io.Copy(io.Discard, server)
+		server.Close()
+	}
+
+	if got := counters.closed[sideClient][closeFIN].Load(); got != 1 {
+		t.Errorf("client fin = %d, want 1", got)
+	}
+	if got := counters.closed[sideClient][closeReset].Load(); got != 1 {
+		t.Errorf("client reset = %d, want 1", got)
+	}
+	if got := counters.reasons[reasonClient].Load(); got != 2 {
+		t.Errorf("closed by client = %d, want 2", got)
+	}
+}
+
+func TestActive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	counters := &Counters{}
+	tl := &trackedListener{Listener: ln, protocol: protocolHTTP, counters: counters}
+	var servers []net.Conn
+	for i := 0; i < 3; i++ {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		server, err := tl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, server)
+	}
+	servers[1].Close()
+	defer servers[0].Close()
+	defer servers[2].Close()
+
+	got := []uint64{}
+	for _, r := range counters.Active() {
+		got = append(got, r.ID)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Errorf("active IDs = %v, want [1 3]", got)
+	}
+}
+
+func TestCloseReason(t *testing.T) {
+	for _, tc := range []struct {
+		s    *session
+		want closeReason
+	}{
+		{&session{}, reasonUpstream},
+		{&session{clientEnded: true}, reasonClient},
+		{&session{dialFailed: true, clientEnded: true}, reasonDialError},
+		{&session{drained: true, clientEnded: true}, reasonDrained},
+		{&session{idle: true, dialFailed: true}, reasonIdle},
+	} {
+		if got := tc.s.closeReason(); got != tc.want {
+			t.Errorf("closeReason of dialFailed=%v clientEnded=%v drained=%v = %s, want %s",
+				tc.s.dialFailed, tc.s.clientEnded, tc.s.drained, reasonNames[got], reasonNames[tc.want])
+		}
+	}
+}
+
+func TestTrackDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	counters := &Counters{}
+	tl := &trackedListener{Listener: ln, protocol: protocolSOCKS5, counters: counters}
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := tl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	d := &net.Dialer{}
+	upstream, err := trackDial(d.DialContext, counters)(connContext(context.Background(), server), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	r := server.(*trackedConn).record()
+	if r.Destination.String() != ln.Addr().String() || r.Source.String() != upstream.LocalAddr().String() {
+		t.Errorf("destination %s from source %s, want %s from %s", r.Destination, r.Source, ln.Addr(), upstream.LocalAddr())
+	}
+	if r.Host != "127.0.0.1" {
+		t.Errorf("host = %q, want 127.0.0.1", r.Host)
+	}
+}
+
+func TestTraceReuse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var d net.Dialer
+	counters := &Counters{}
+	records := make(chan Record, 2)
+	go Proxy{
+		Dial:              d.DialContext,
+		Counters:          counters,
+		UpstreamIdleConns: 1,
+		OnClose:           func(r Record) { records <- r },
+	}.Serve(ln)
+
+	proxyURL, _ := url.Parse("http://" + ln.Addr().String())
+	// Each request is from a new client connection, and the second one
+	// reuses the upstream connection dialed for the first one.
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: true}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		select {
+		case r := <-records:
+			if r.Destination == nil || r.Destination.String() != upstream.Listener.Addr().String() || r.Source == nil || r.Host != "127.0.0.1" {
+				t.Errorf("request %d: destination %v from source %v of host %q, want %s of 127.0.0.1", i, r.Destination, r.Source, r.Host, upstream.Listener.Addr())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request %d: client connection isn't closed", i)
+		}
+	}
+	if dialed := counters.upstreamDialed.Load(); dialed != 1 {
+		t.Errorf("upstream dialed %d times, want 1", dialed)
+	}
+}
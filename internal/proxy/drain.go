@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// drainHandler closes the active client connections to a destination on
+// POST /drain?destination=, and responds with the number of connections
+// closed. The destination is a host, an IP address or a CIDR.
+func drainHandler(next http.Handler, counters *Counters) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/drain" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		destination := r.URL.Query().Get("destination")
+		if destination == "" {
+			http.Error(rw, "missing destination", http.StatusBadRequest)
+			return
+		}
+
+		closed := counters.drain(matchDestination(destination))
+		resp, _ := json.Marshal(struct{ Closed int }{closed})
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write(append(resp, '\n'))
+	})
+}
+
+// drain closes the active client connections whose destination matches,
+// and returns the number of them.
+func (c *Counters) drain(match func(host string, destination net.Addr) bool) int {
+	closed := 0
+	c.conns.Range(func(key, _ any) bool {
+		tc := key.(*trackedConn)
+		tc.session.mu.Lock()
+		matched := tc.session.destination != nil && match(tc.session.host, tc.session.destination)
+		if matched {
+			tc.session.drained = true
+		}
+		tc.session.mu.Unlock()
+		if matched {
+			tc.Close()
+			closed++
+		}
+		return true
+	})
+	return closed
+}
+
+// matchDestination returns a function reporting whether a connection to
+// host, which is resolved to destination, matches target.
+func matchDestination(target string) func(host string, destination net.Addr) bool {
+	prefix, err := netip.ParsePrefix(target)
+	if err != nil {
+		if ip, err := netip.ParseAddr(target); err == nil {
+			prefix = netip.PrefixFrom(ip, ip.BitLen())
+		}
+	}
+	prefix = prefix.Masked()
+
+	return func(host string, destination net.Addr) bool {
+		if !prefix.IsValid() {
+			return strings.EqualFold(strings.TrimSuffix(host, "."), strings.TrimSuffix(target, "."))
+		}
+		tcpAddr, ok := destination.(*net.TCPAddr)
+		if !ok {
+			return false
+		}
+		return prefix.Contains(tcpAddr.AddrPort().Addr().Unmap())
+	}
+}
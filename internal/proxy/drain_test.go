@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMatchDestination(t *testing.T) {
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+	for _, tc := range []struct {
+		target string
+		host   string
+		want   bool
+	}{
+		{"10.0.0.1", "example.com", true},
+		{"10.0.0.0/24", "example.com", true},
+		{"10.0.1.0/24", "example.com", false},
+		{"example.com", "Example.com", true},
+		{"example.com", "www.example.com", false},
+		{"example.com", "10.0.0.1", false},
+	} {
+		if got := matchDestination(tc.target)(tc.host, dst); got != tc.want {
+			t.Errorf("matchDestination(%q)(%q, %s) = %v, want %v", tc.target, tc.host, dst, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// healthHandler serves a health check at path, which responds body if
+// check succeeds, or 503 Service Unavailable otherwise. The response is
+// kept minimal for load balancers, like HAProxy's "http-check expect".
+func healthHandler(next http.Handler, path, body string, check func() error) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "" || r.URL.Path != path {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if !allowGet(rw, r) {
+			return
+		}
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.Header().Set("Cache-Control", "no-store")
+		if err := check(); err != nil {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		_, _ = rw.Write([]byte(body))
+	})
+}
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	var checkErr error
+	h := healthHandler(http.NotFoundHandler(), "/healthz", "OK", func() error { return checkErr })
+
+	for _, tc := range []struct {
+		path     string
+		checkErr error
+		code     int
+		body     string
+	}{
+		{"/healthz", nil, http.StatusOK, "OK"},
+		{"/healthz", errors.New("device is down"), http.StatusServiceUnavailable, "device is down"},
+		{"/other", nil, http.StatusNotFound, "404 page not found\n"},
+	} {
+		checkErr = tc.checkErr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.code || rec.Body.String() != tc.body {
+			t.Errorf("GET %s with check error %v = %d %q, want %d %q", tc.path, tc.checkErr, rec.Code, rec.Body, tc.code, tc.body)
+		}
+	}
+}
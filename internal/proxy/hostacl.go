@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+var errHostDenied = fmt.Errorf("destination host is not allowed: %w", os.ErrPermission)
+
+// dialWithAllowedHosts only dials destinations whose host, as requested by
+// client, matches one of allowed.
+func dialWithAllowedHosts(dial dialer, allowed []string) dialer {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		for _, pattern := range allowed {
+			if matchHost(pattern, host) {
+				return dial(ctx, network, address)
+			}
+		}
+		return nil, fmt.Errorf("dial %s: %w", address, errHostDenied)
+	}
+}
+
+// matchHost reports whether host matches pattern, which is a domain, a
+// wildcard like *.example.com for its subdomains, or an IP address or CIDR
+// for IP hosts. Domains are matched case-insensitively.
+func matchHost(pattern, host string) bool {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap().WithZone("")
+		if prefix, err := netip.ParsePrefix(pattern); err == nil {
+			return prefix.Contains(ip)
+		}
+		if p, err := netip.ParseAddr(pattern); err == nil {
+			return p.Unmap() == ip
+		}
+		return false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[len("*"):])
+	}
+	return host == pattern
+}
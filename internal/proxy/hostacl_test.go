@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestMatchHost(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "Example.COM.", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"192.0.2.1", "192.0.2.1", true},
+		{"192.0.2.1", "::ffff:192.0.2.1", true},
+		{"192.0.2.0/24", "192.0.2.9", true},
+		{"192.0.2.0/24", "198.51.100.1", false},
+		{"2001:db8::/32", "2001:db8::1", true},
+		{"example.com", "192.0.2.1", false},
+		{"192.0.2.1", "example.com", false},
+	} {
+		if got := matchHost(tc.pattern, tc.host); got != tc.want {
+			t.Errorf("matchHost(%q, %q) = %v, want %v", tc.pattern, tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestDialWithAllowedHosts(t *testing.T) {
+	dialed := ""
+	dial := dialWithAllowedHosts(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = address
+		return nil, nil
+	}, []string{"*.example.com", "10.0.0.0/8"})
+
+	for address, allowed := range map[string]bool{
+		"www.example.com:443": true,
+		"10.1.2.3:80":         true,
+		"example.org:443":     false,
+		"192.0.2.1:80":        false,
+	} {
+		dialed = ""
+		_, err := dial(context.Background(), "tcp", address)
+		if allowed && (err != nil || dialed != address) {
+			t.Errorf("dial %s: %v, want allowed", address, err)
+		}
+		if !allowed && (!errors.Is(err, os.ErrPermission) || dialed != "") {
+			t.Errorf("dial %s: %v, want permission denied", address, err)
+		}
+	}
+}
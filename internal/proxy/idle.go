@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"time"
+)
+
+// reapIdle closes client connections without traffic in either direction
+// for timeout, e.g. when the client is gone without FIN or RST. Otherwise
+// such half-open connections are kept until the destination closes them,
+// since connections in WireGuard network don't have TCP keepalive.
+func (c *Counters) reapIdle(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		c.closeIdle(now.Add(-timeout))
+	}
+}
+
+// closeIdle closes the client connections not active since before, and
+// returns the number of them.
+func (c *Counters) closeIdle(before time.Time) int {
+	closed := 0
+	c.conns.Range(func(key, _ any) bool {
+		tc := key.(*trackedConn)
+		if tc.session.lastActive.Load() >= before.UnixNano() {
+			return true
+		}
+		tc.session.mu.Lock()
+		tc.session.idle = true
+		tc.session.mu.Unlock()
+		tc.Close()
+		closed++
+		return true
+	})
+	return closed
+}
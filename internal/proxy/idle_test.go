@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCloseIdle(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	counters := &Counters{}
+	tl := &trackedListener{Listener: ln, protocol: protocolSOCKS5, counters: counters}
+	var servers []*trackedConn
+	for i := 0; i < 2; i++ {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		server, err := tl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		servers = append(servers, server.(*trackedConn))
+	}
+
+	before := time.Now()
+	servers[0].session.lastActive.Store(before.Add(-time.Minute).UnixNano())
+	if _, err := servers[1].Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	if closed := counters.closeIdle(before); closed != 1 {
+		t.Errorf("closed %d connections, want 1", closed)
+	}
+	if got := counters.reasons[reasonIdle].Load(); got != 1 {
+		t.Errorf("closed by idle = %d, want 1", got)
+	}
+	if active := counters.Active(); len(active) != 1 || active[0].ID != 2 {
+		t.Errorf("active connections = %+v, want ID 2", active)
+	}
+}
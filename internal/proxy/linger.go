@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"context"
+	"net"
+)
+
+// lingerer is implemented by *net.TCPConn. Connections in netstack don't
+// support it.
+type lingerer interface {
+	SetLinger(sec int) error
+}
+
+func setLinger(c net.Conn, sec int) {
+	if l, ok := c.(lingerer); ok {
+		_ = l.SetLinger(sec)
+	}
+}
+
+type lingerListener struct {
+	net.Listener
+	sec int
+}
+
+func (l *lingerListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	setLinger(c, l.sec)
+	return c, nil
+}
+
+func dialWithLinger(dial dialer, sec int) dialer {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		c, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		setLinger(c, sec)
+		return c, nil
+	}
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestLingerListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ll := &lingerListener{Listener: ln, sec: 0}
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ll.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	_, err = client.Read(make([]byte, 1))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("read after close = %v, want connection reset", err)
+	}
+}
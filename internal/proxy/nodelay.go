@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"context"
+	"net"
+)
+
+// noDelayer is implemented by *net.TCPConn. Connections in netstack don't
+// support it.
+type noDelayer interface {
+	SetNoDelay(noDelay bool) error
+}
+
+func setNoDelay(c net.Conn, noDelay bool) {
+	if d, ok := c.(noDelayer); ok {
+		_ = d.SetNoDelay(noDelay)
+	}
+}
+
+type noDelayListener struct {
+	net.Listener
+	noDelay bool
+}
+
+func (l *noDelayListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	setNoDelay(c, l.noDelay)
+	return c, nil
+}
+
+func dialWithNoDelay(dial dialer, noDelay bool) dialer {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		c, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		setNoDelay(c, noDelay)
+		return c, nil
+	}
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// pacHandler serves a Proxy Auto-Config file at /proxy.pac, which uses the
+// HTTP proxy at addr for domains and their subdomains, or for everything if
+// domains is empty.
+func pacHandler(next http.Handler, addr string, domains []string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "" || r.URL.Path != "/proxy.pac" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if !allowGet(rw, r) {
+			return
+		}
+		rw.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		_, _ = rw.Write([]byte(pacScript(pacProxyAddr(addr, r.Host), domains)))
+	})
+}
+
+// pacProxyAddr replaces the unspecified host of addr with the host which
+// client uses to reach the server.
+func pacProxyAddr(addr, reqHost string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip, err := netip.ParseAddr(host); err != nil || !ip.IsUnspecified() {
+		return addr
+	}
+	if h, _, err := net.SplitHostPort(reqHost); err == nil {
+		reqHost = h
+	}
+	reqHost = strings.TrimSuffix(strings.TrimPrefix(reqHost, "["), "]")
+	if reqHost == "" {
+		return addr
+	}
+	return net.JoinHostPort(reqHost, port)
+}
+
+func pacScript(addr string, domains []string) string {
+	var b strings.Builder
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	if len(domains) == 0 {
+		fmt.Fprintf(&b, "  return %s;\n}\n", strconv.Quote("PROXY "+addr))
+		return b.String()
+	}
+	for _, domain := range domains {
+		domain = strings.Trim(domain, ".")
+		fmt.Fprintf(&b, "  if (host == %s || dnsDomainIs(host, %s)) {\n", strconv.Quote(domain), strconv.Quote("."+domain))
+		fmt.Fprintf(&b, "    return %s;\n  }\n", strconv.Quote("PROXY "+addr))
+	}
+	b.WriteString("  return \"DIRECT\";\n}\n")
+	return b.String()
+}
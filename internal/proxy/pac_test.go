@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPACProxyAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr, reqHost, want string
+	}{
+		{"127.0.0.1:8080", "example.com:9090", "127.0.0.1:8080"},
+		{"0.0.0.0:8080", "192.168.1.1:9090", "192.168.1.1:8080"},
+		{"[::]:8080", "[fd00::1]:9090", "[fd00::1]:8080"},
+		{"[::]:8080", "proxy.lan", "proxy.lan:8080"},
+		{"[::]:8080", "", "[::]:8080"},
+	} {
+		if got := pacProxyAddr(tc.addr, tc.reqHost); got != tc.want {
+			t.Errorf("pacProxyAddr(%q, %q) = %q, want %q", tc.addr, tc.reqHost, got, tc.want)
+		}
+	}
+}
+
+func TestPACScript(t *testing.T) {
+	script := pacScript("127.0.0.1:8080", nil)
+	if !strings.Contains(script, `return "PROXY 127.0.0.1:8080";`) {
+		t.Errorf("script without domains:\n%s", script)
+	}
+
+	script = pacScript("127.0.0.1:8080", []string{".example.com"})
+	for _, s := range []string{`host == "example.com"`, `dnsDomainIs(host, ".example.com")`, `return "DIRECT";`} {
+		if !strings.Contains(script, s) {
+			t.Errorf("script with domains doesn't contain %s:\n%s", s, script)
+		}
+	}
+}
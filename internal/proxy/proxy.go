@@ -2,9 +2,19 @@ package proxy
 
 import (
 	"context"
+	_ "embed"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
 	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"time"
+
+	"golang.org/x/time/rate"
 
 	"github.com/zhsj/wghttp/internal/resolver"
 	"github.com/zhsj/wghttp/internal/third_party/tailscale/httpproxy"
@@ -15,9 +25,139 @@ import (
 type dialer func(ctx context.Context, network, address string) (net.Conn, error)
 
 type Proxy struct {
-	Dial  dialer
-	DNS   string
-	Stats func() (any, error)
+	Dial     dialer
+	DNS      string
+	Stats    func() (any, error)
+	Counters *Counters
+
+	// DialTimeout limits the time of dialing destinations if it's not
+	// zero. HTTP proxy clients can override it with DialTimeoutHeader, up
+	// to MaxDialTimeout, or the header is ignored if it's zero.
+	DialTimeout    time.Duration
+	MaxDialTimeout time.Duration
+	// AdaptiveDialTimeoutMin and AdaptiveDialTimeoutMax make the dial
+	// timeout adapt to the latency of recent dials within them, starting
+	// from DialTimeout, if both are set.
+	AdaptiveDialTimeoutMin time.Duration
+	AdaptiveDialTimeoutMax time.Duration
+
+	// DNSRetransmits and DNSRetransmitInterval control resending of
+	// queries for UDP DNS.
+	DNSRetransmits        int
+	DNSRetransmitInterval time.Duration
+	// DNSTLSMinVersion and DNSTLSCipherSuites restrict TLS for DNS over
+	// TLS and DNS over HTTPS.
+	DNSTLSMinVersion   uint16
+	DNSTLSCipherSuites []uint16
+	// DNSRecord records lookups of destinations if it's not nil, and
+	// DNSReplay answers lookups from the recorded ones if it's not nil.
+	DNSRecord io.Writer
+	DNSReplay []resolver.Lookup
+	// DNSStats counts lookups of destinations if it's not nil.
+	DNSStats *resolver.Stats
+	// DNSSourcePort fixes the source port of queries for UDP DNS if it's
+	// not zero, which are dialed by DNSDialUDP.
+	DNSSourcePort uint16
+	DNSDialUDP    resolver.DialUDP
+	// DNSFallback are DNS servers in the same format as DNS, or system for
+	// the system resolver, which are tried in order if DNS fails other than
+	// not found. Lookups of DNS are limited to DNSFallbackTimeout then.
+	DNSFallback        []string
+	DNSFallbackTimeout time.Duration
+
+	// AllowedHosts limits destinations to hosts matching these patterns if
+	// not empty. Patterns are domains, wildcards like *.example.com, or IP
+	// addresses and CIDRs. Hosts are matched as requested by client, before
+	// resolving domains.
+	AllowedHosts []string
+
+	// Network restricts destinations to an address family if it's tcp4 or
+	// tcp6, e.g. when the tunnel only has IPv4 address.
+	Network string
+
+	// AllowedSources limits the source addresses of client connections if
+	// not empty.
+	AllowedSources []netip.Prefix
+	// ClientNetwork limits client connections to an address family if it's
+	// tcp4 or tcp6, even if the listener accepts both.
+	ClientNetwork string
+
+	// AcceptRate limits new connections per second, with bursts of
+	// AcceptBurst. Zero means no limit.
+	AcceptRate  float64
+	AcceptBurst int
+
+	// TotalRate limits the total throughput of client connections in bytes
+	// per second, with bursts of TotalBurst. Zero means no limit.
+	TotalRate  float64
+	TotalBurst int
+
+	// RelayBufferMemory limits the total size in bytes of buffers relaying
+	// data between clients and destinations, other than HTTP requests
+	// without CONNECT. Zero means no limit.
+	RelayBufferMemory int
+
+	// ClientLinger and UpstreamLinger set SO_LINGER of client and upstream
+	// connections if they're not nil, like net.TCPConn.SetLinger. It only
+	// applies to connections in local network.
+	ClientLinger   *int
+	UpstreamLinger *int
+
+	// TCPDelay enables Nagle's algorithm on client and upstream connections
+	// in local network. By default, TCP_NODELAY is set like net.TCPConn.
+	TCPDelay bool
+	// TCPQuickAck sets TCP_QUICKACK on client and upstream connections in
+	// local network, to send ACKs immediately. It's only supported on
+	// Linux.
+	TCPQuickAck bool
+
+	// IdleTimeout closes client connections without traffic in either
+	// direction for this duration if it's not zero, which reclaims
+	// half-open connections of clients gone without closing them.
+	IdleTimeout time.Duration
+
+	// UpstreamIdleConns and UpstreamIdleTimeout control the pool of idle
+	// upstream connections of HTTP proxy, like MaxIdleConnsPerHost and
+	// IdleConnTimeout of http.Transport.
+	UpstreamIdleConns   int
+	UpstreamIdleTimeout time.Duration
+
+	// WebUI enables a web page showing stats on admin server.
+	WebUI bool
+
+	// PACProxy enables serving a PAC file at /proxy.pac, which points to
+	// the proxy at this address for PACDomains, or for all domains if
+	// PACDomains is empty.
+	PACProxy   string
+	PACDomains []string
+
+	// WGQuickConf returns the running config as a wg-quick file, with keys
+	// if requested. It's served at /wg-quick.conf on admin server if it's
+	// not nil. Keys can only be requested if WGQuickKeys is set.
+	WGQuickConf func(keys bool) (string, error)
+	WGQuickKeys bool
+
+	// HealthPath enables serving a health check at this path, which
+	// responds HealthBody if Health succeeds, or Stats succeeds if Health
+	// is nil.
+	HealthPath string
+	HealthBody string
+	Health     func() error
+
+	// ForwardTo makes Serve forward every client connection to this
+	// address, instead of serving HTTP and SOCKS5 proxy.
+	ForwardTo string
+
+	// SelfAddrs are the addresses of listeners in the network of Dial,
+	// which are rejected as destination to avoid loops. Listeners in other
+	// networks can't be reached by Dial, and their addresses may be valid
+	// destinations, so they shouldn't be included.
+	SelfAddrs []net.Addr
+
+	// OnClose is called with the record of each closed client connection.
+	OnClose func(Record)
+	// OnRequest is called with each finished HTTP proxy request.
+	OnRequest func(Request)
 }
 
 func statsHandler(next http.Handler, stats func() (any, error)) http.Handler {
@@ -26,6 +166,9 @@ func statsHandler(next http.Handler, stats func() (any, error)) http.Handler {
 			next.ServeHTTP(rw, r)
 			return
 		}
+		if !allowGet(rw, r) {
+			return
+		}
 		s, err := stats()
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
@@ -37,9 +180,121 @@ func statsHandler(next http.Handler, stats func() (any, error)) http.Handler {
 	})
 }
 
-func dialWithDNS(dial dialer, dns string) dialer {
-	resolv := resolver.New(dns, dial)
+// allowGet responds 405 Method Not Allowed if r isn't GET or HEAD, and
+// reports whether it's allowed.
+func allowGet(rw http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	rw.Header().Set("Allow", "GET, HEAD")
+	http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+//go:embed ui.html
+var uiPage []byte
+
+func uiHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if !allowGet(rw, r) {
+			return
+		}
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = rw.Write(uiPage)
+	})
+}
+
+// ServeAdmin serves the admin endpoints on ln.
+func (p Proxy) ServeAdmin(ln net.Listener) error {
+	return http.Serve(ln, p.AdminHandler())
+}
+
+// AdminHandler returns the handler of admin endpoints.
+func (p Proxy) AdminHandler() http.Handler {
+	var h http.Handler = http.NotFoundHandler()
+	if p.WebUI {
+		h = uiHandler(h)
+	}
+	if p.Counters != nil {
+		h = drainHandler(h, p.Counters)
+	}
+	if p.WGQuickConf != nil {
+		h = wgQuickHandler(h, p.WGQuickConf, p.WGQuickKeys)
+	}
+	return statsHandler(p.healthHandler(p.pacHandler(h)), p.Stats)
+}
+
+func (p Proxy) healthHandler(next http.Handler) http.Handler {
+	if p.HealthPath == "" {
+		return next
+	}
+	check := p.Health
+	if check == nil {
+		check = func() error {
+			_, err := p.Stats()
+			return err
+		}
+	}
+	return healthHandler(next, p.HealthPath, p.HealthBody, check)
+}
+
+func (p Proxy) pacHandler(next http.Handler) http.Handler {
+	if p.PACProxy == "" {
+		return next
+	}
+	return pacHandler(next, p.PACProxy, p.PACDomains)
+}
+
+func (p Proxy) logRequests(next http.Handler) http.Handler {
+	if p.OnRequest == nil {
+		return next
+	}
+	return logRequests(next, p.OnRequest)
+}
+
+var errLoop = fmt.Errorf("destination is proxy itself: %w", os.ErrPermission)
+
+func dialWithoutLoop(dial dialer, self []net.Addr) dialer {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if isSelf(address, self) {
+			return nil, fmt.Errorf("dial %s: %w", address, errLoop)
+		}
+		return dial(ctx, network, address)
+	}
+}
+
+func isSelf(address string, self []net.Addr) bool {
+	dst, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return false
+	}
+	dstIP := dst.Addr().Unmap()
+
+	for _, addr := range self {
+		tcpAddr, ok := addr.(*net.TCPAddr)
+		if !ok {
+			continue
+		}
+		ap := tcpAddr.AddrPort()
+		if ap.Port() != dst.Port() {
+			continue
+		}
+		ip := ap.Addr().Unmap()
+		if ip == dstIP {
+			return true
+		}
+		if ip.IsUnspecified() && (dstIP.IsLoopback() || dstIP.IsUnspecified()) {
+			return true
+		}
+	}
+	return false
+}
 
+func dialWithDNS(dial dialer, resolv *resolver.Resolver) dialer {
 	return func(ctx context.Context, network, address string) (net.Conn, error) {
 		host, port, err := net.SplitHostPort(address)
 		if err != nil {
@@ -71,13 +326,127 @@ func dialWithDNS(dial dialer, dns string) dialer {
 	}
 }
 
+// dialWithNetwork replaces tcp network with the specific one if it's set.
+func dialWithNetwork(dial dialer, specific string) dialer {
+	if specific == "" {
+		return dial
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if network == "tcp" {
+			network = specific
+		}
+		return dial(ctx, network, address)
+	}
+}
+
+// upstreamDialer returns the dialer for destinations, which rejects self
+// addresses and resolves domains with DNS.
+func (p Proxy) upstreamDialer(self []net.Addr) dialer {
+	dial := dialWithTimeout(p.Dial, p.DialTimeout)
+	if p.Counters != nil && p.Counters.dialTimeout != nil {
+		dial = dialWithAdaptiveTimeout(p.Dial, p.Counters.dialTimeout)
+	}
+	dial = dialWithoutLoop(dial, self)
+	if p.UpstreamLinger != nil {
+		dial = dialWithLinger(dial, *p.UpstreamLinger)
+	}
+	dial = dialWithNoDelay(dial, !p.TCPDelay)
+	if p.TCPQuickAck {
+		dial = dialWithQuickAck(dial)
+	}
+	resolv := p.newResolver(p.DNS, dial)
+	resolv.Stats = p.DNSStats
+	resolv.SetSourcePort(p.DNSSourcePort, p.DNSDialUDP)
+	if len(p.DNSFallback) > 0 {
+		fallbacks := []*resolver.Resolver{}
+		for _, dns := range p.DNSFallback {
+			if dns == "system" {
+				fallbacks = append(fallbacks, resolver.New("", nil))
+			} else {
+				fallbacks = append(fallbacks, p.newResolver(dns, dial))
+			}
+		}
+		resolv.SetFallback(p.DNSFallbackTimeout, fallbacks...)
+	}
+	if p.DNSRecord != nil {
+		resolv.Record(p.DNSRecord)
+	}
+	if p.DNSReplay != nil {
+		resolv.Replay(p.DNSReplay)
+	}
+	dial = dialWithNetwork(dialWithDNS(dial, resolv), p.Network)
+	if len(p.AllowedHosts) > 0 {
+		dial = dialWithAllowedHosts(dial, p.AllowedHosts)
+	}
+	return dial
+}
+
+// newResolver returns the resolver of DNS server dns for destinations.
+func (p Proxy) newResolver(dns string, dial dialer) *resolver.Resolver {
+	resolv := resolver.New(dns, dial)
+	resolv.Retransmits = p.DNSRetransmits
+	resolv.RetransmitInterval = p.DNSRetransmitInterval
+	resolv.SetTLS(p.DNSTLSMinVersion, p.DNSTLSCipherSuites)
+	return resolv
+}
+
 func (p Proxy) Serve(ln net.Listener) {
-	d := dialWithDNS(p.Dial, p.DNS)
+	if p.Counters == nil {
+		p.Counters = &Counters{}
+	}
+	if p.AdaptiveDialTimeoutMin > 0 && p.AdaptiveDialTimeoutMax > 0 {
+		p.Counters.dialTimeout = newAdaptiveTimeout(p.DialTimeout, p.AdaptiveDialTimeoutMin, p.AdaptiveDialTimeoutMax)
+	}
+	d := trackDial(p.upstreamDialer(p.SelfAddrs), p.Counters)
+
+	if p.TotalRate > 0 {
+		p.Counters.bandwidth = newBandwidth(p.TotalRate, p.TotalBurst)
+	}
+	if p.RelayBufferMemory > 0 {
+		p.Counters.buffers = newBuffers(p.RelayBufferMemory)
+	}
+	if p.IdleTimeout > 0 {
+		go p.Counters.reapIdle(p.IdleTimeout)
+	}
 
+	if p.ClientLinger != nil {
+		ln = &lingerListener{Listener: ln, sec: *p.ClientLinger}
+	}
+	ln = &noDelayListener{Listener: ln, noDelay: !p.TCPDelay}
+	if p.TCPQuickAck {
+		ln = &quickAckListener{Listener: ln}
+	}
+	if p.ClientNetwork == "tcp4" || p.ClientNetwork == "tcp6" {
+		ln = &familyListener{Listener: ln, network: p.ClientNetwork, counters: p.Counters}
+	}
+	if len(p.AllowedSources) > 0 {
+		ln = &aclListener{Listener: ln, allowed: p.AllowedSources, counters: p.Counters}
+	}
+	if p.AcceptRate > 0 {
+		burst := p.AcceptBurst
+		if burst <= 0 {
+			burst = int(math.Ceil(p.AcceptRate))
+		}
+		ln = &rateLimitedListener{Listener: ln, limiter: rate.NewLimiter(rate.Limit(p.AcceptRate), burst)}
+	}
+	if p.ForwardTo != "" {
+		p.forward(&trackedListener{Listener: ln, protocol: protocolForward, counters: p.Counters, onClose: p.OnClose}, d)
+		return
+	}
 	socksListener, httpListener := proxymux.SplitSOCKSAndHTTP(ln)
+	socksListener = &trackedListener{Listener: socksListener, protocol: protocolSOCKS5, counters: p.Counters, onClose: p.OnClose}
+	httpListener = &trackedListener{Listener: httpListener, protocol: protocolHTTP, counters: p.Counters, onClose: p.OnClose}
 
-	httpProxy := &http.Server{Handler: statsHandler(httpproxy.Handler(d), p.Stats)}
-	socksProxy := &socks5.Server{Dialer: d}
+	transport := &http.Transport{
+		DialContext:         d,
+		MaxIdleConnsPerHost: p.UpstreamIdleConns,
+		IdleConnTimeout:     p.UpstreamIdleTimeout,
+	}
+	httpProxy := &http.Server{
+		Handler:     classifyHTTP(statsHandler(p.healthHandler(p.pacHandler(p.logRequests(dialTimeoutHandler(traceReuse(readBodyAhead(httpproxy.HandlerWithTransport(transport, p.Counters.copy))), p.MaxDialTimeout)))), p.Stats)),
+		ConnContext: connContext,
+	}
+	socksProxy := &socks5.Server{Dialer: d, ConnContext: connContext, Copy: p.Counters.copy}
 
 	errc := make(chan error, 2)
 	go func() {
@@ -92,3 +461,68 @@ func (p Proxy) Serve(ln net.Listener) {
 	}()
 	<-errc
 }
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Relay connects to address, and relays data between it and rw, which is a
+// single client like stdin and stdout. It returns when the destination
+// closes the connection, or either side fails.
+func (p Proxy) Relay(ctx context.Context, rw io.ReadWriter, address string) error {
+	c, err := p.upstreamDialer(p.SelfAddrs)(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return relay(rw, c, io.Copy)
+}
+
+// DialUpstream connects to address like destinations of proxy clients,
+// e.g. to check that it's reachable.
+func (p Proxy) DialUpstream(ctx context.Context, network, address string) (net.Conn, error) {
+	return p.upstreamDialer(p.SelfAddrs)(ctx, network, address)
+}
+
+// forward relays every connection accepted from ln to ForwardTo.
+func (p Proxy) forward(ln net.Listener, dial dialer) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			upstream, err := dial(connContext(context.Background(), c), "tcp", p.ForwardTo)
+			if err != nil {
+				log.Printf("forward: client connection from %s failed: %v", c.RemoteAddr(), err)
+				return
+			}
+			defer upstream.Close()
+			_ = relay(c, upstream, p.Counters.copy)
+		}()
+	}
+}
+
+// relay copies data between client and upstream. It returns when upstream
+// closes the connection, or either side fails.
+func relay(client io.ReadWriter, upstream net.Conn, copy copyFunc) error {
+	errc := make(chan error, 2)
+	go func() {
+		_, err := copy(upstream, client)
+		if err == nil {
+			// Keep receiving from destination after client finishes,
+			// which is told by half-close if it's supported.
+			if cw, ok := upstream.(closeWriter); ok {
+				_ = cw.CloseWrite()
+			}
+			return
+		}
+		errc <- err
+	}()
+	go func() {
+		_, err := copy(client, upstream)
+		errc <- err
+	}()
+	return <-errc
+}
@@ -1,10 +1,22 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/zhsj/wghttp/internal/resolver"
 )
 
 func TestDialWithDNS(t *testing.T) {
@@ -20,11 +32,12 @@ func TestDialWithDNS(t *testing.T) {
 		},
 	}
 
-	// d := dialWithDNS(stdDiar.DialContext, "https://223.5.5.5")
-	d := dialWithDNS(func(ctx context.Context, network, address string) (net.Conn, error) {
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
 		t.Logf("dial to %s:%s", network, address)
 		return stdDiar.DialContext(ctx, network, address)
-	}, "tls://223.5.5.5")
+	}
+	// d := dialWithDNS(stdDiar.DialContext, resolver.New("https://223.5.5.5", stdDiar.DialContext))
+	d := dialWithDNS(dial, resolver.New("tls://223.5.5.5", dial))
 
 	for _, addr := range []string{
 		"example.com:80",
@@ -43,3 +56,272 @@ func TestDialWithDNS(t *testing.T) {
 		})
 	}
 }
+
+func TestIsSelf(t *testing.T) {
+	self := []net.Addr{
+		&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080},
+		&net.TCPAddr{IP: net.IPv6unspecified, Port: 9090},
+	}
+
+	for addr, want := range map[string]bool{
+		"127.0.0.1:8080":   true,
+		"127.0.0.1:8081":   false,
+		"10.0.0.1:8080":    false,
+		"127.0.0.1:9090":   true,
+		"[::1]:9090":       true,
+		"10.0.0.1:9090":    false,
+		"example.com:8080": false,
+	} {
+		if got := isSelf(addr, self); got != want {
+			t.Errorf("isSelf(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		c, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		// Echo until client half-closes.
+		_, _ = io.Copy(c, c)
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var d net.Dialer
+	counters := &Counters{}
+	go Proxy{Dial: d.DialContext, Counters: counters, ForwardTo: upstream.Addr().String()}.Serve(ln)
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// It's not a HTTP or SOCKS5 request, which is forwarded as is.
+	want := "\x05\x01\x00GET / HTTP/1.1\r\n\r\n"
+	if _, err := io.WriteString(c, want); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.(*net.TCPConn).CloseWrite()
+	got, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if total := counters.protocols[protocolForward].total.Load(); total != 1 {
+		t.Errorf("forward connections = %d, want 1", total)
+	}
+}
+
+func TestForwardDialError(t *testing.T) {
+	// The port is closed after it's allocated, so dials are refused.
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream.Close()
+
+	var logs bytes.Buffer
+	var logsMu sync.Mutex
+	log.SetOutput(writerFunc(func(b []byte) (int, error) {
+		logsMu.Lock()
+		defer logsMu.Unlock()
+		return logs.Write(b)
+	}))
+	defer log.SetOutput(os.Stderr)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var d net.Dialer
+	go Proxy{Dial: d.DialContext, ForwardTo: upstream.Addr().String()}.Serve(ln)
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read from client connection = %v, want EOF", err)
+	}
+
+	logsMu.Lock()
+	defer logsMu.Unlock()
+	want := fmt.Sprintf("forward: client connection from %s failed: ", c.LocalAddr())
+	if !strings.Contains(logs.String(), want) || !strings.Contains(logs.String(), upstream.Addr().String()) {
+		t.Errorf("logs = %q, want %q with %s", logs.String(), want, upstream.Addr())
+	}
+}
+
+func TestServeDialsOwnAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// The dialer is in another network, where the same address isn't the
+	// proxy itself.
+	dialed := make(chan string, 1)
+	dial := func(_ context.Context, _, address string) (net.Conn, error) {
+		dialed <- address
+		return nil, errors.New("unreachable")
+	}
+	go Proxy{Dial: dial, ForwardTo: ln.Addr().String()}.Serve(ln)
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	select {
+	case address := <-dialed:
+		if address != ln.Addr().String() {
+			t.Errorf("dialed %s, want %s", address, ln.Addr())
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("address of proxy listener isn't dialed")
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) { return f(b) }
+
+func TestRelayWithoutCloseWrite(t *testing.T) {
+	// net.Pipe doesn't support half-close.
+	upstream, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, _ = io.WriteString(server, "pong")
+	}()
+
+	var out bytes.Buffer
+	client := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader("ping"), &out}
+	if err := relay(client, upstream, io.Copy); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "pong" {
+		t.Errorf("client received %q after its EOF, want %q", out.String(), "pong")
+	}
+}
+
+func TestCancelDialOnClientClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	dialing, canceled := make(chan struct{}), make(chan struct{})
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		dialing <- struct{}{}
+		<-ctx.Done()
+		canceled <- struct{}{}
+		return nil, ctx.Err()
+	}
+	go Proxy{Dial: dial}.Serve(ln)
+
+	for _, request := range []string{
+		"CONNECT 192.0.2.1:443 HTTP/1.1\r\nHost: 192.0.2.1:443\r\n\r\n",
+		"GET http://192.0.2.1/ HTTP/1.1\r\nHost: 192.0.2.1\r\n\r\n",
+		// The body isn't read before dialing.
+		"POST http://192.0.2.1/ HTTP/1.1\r\nHost: 192.0.2.1\r\nContent-Length: 4\r\n\r\nbody",
+		"POST http://192.0.2.1/ HTTP/1.1\r\nHost: 192.0.2.1\r\nContent-Length: 100\r\n\r\nincomplete body",
+		"\x05\x01\x00\x05\x01\x00\x01\xc0\x00\x02\x01\x01\xbb",
+	} {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(c, request); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-dialing:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q: destination isn't dialed", request)
+		}
+		c.Close()
+		select {
+		case <-canceled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q: dial isn't canceled after client closes", request)
+		}
+	}
+}
+
+func TestUnsupportedRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var d net.Dialer
+	p := Proxy{
+		Dial:       d.DialContext,
+		Stats:      func() (any, error) { return struct{}{}, nil },
+		HealthPath: "/health",
+		HealthBody: "OK",
+		PACProxy:   ln.Addr().String(),
+	}
+	go p.Serve(ln)
+
+	for _, tc := range []struct {
+		request string
+		status  string
+		allow   string
+	}{
+		{"GET / HTTP/1.1\r\nHost: proxy\r\n\r\n", "405 Method Not Allowed", "CONNECT"},
+		// It's answered by http.Server.
+		{"OPTIONS * HTTP/1.1\r\nHost: proxy\r\n\r\n", "200 OK", ""},
+		{"DELETE /unknown HTTP/1.1\r\nHost: proxy\r\n\r\n", "405 Method Not Allowed", "CONNECT"},
+		{"POST /stats HTTP/1.1\r\nHost: proxy\r\nContent-Length: 0\r\n\r\n", "405 Method Not Allowed", "GET, HEAD"},
+		{"PUT /health HTTP/1.1\r\nHost: proxy\r\nContent-Length: 0\r\n\r\n", "405 Method Not Allowed", "GET, HEAD"},
+		{"POST /proxy.pac HTTP/1.1\r\nHost: proxy\r\nContent-Length: 0\r\n\r\n", "405 Method Not Allowed", "GET, HEAD"},
+		{"HEAD /stats HTTP/1.1\r\nHost: proxy\r\n\r\n", "200 OK", ""},
+		{"GET /health HTTP/1.1\r\nHost: proxy\r\n\r\n", "200 OK", ""},
+		{"GET HTTP/1.1\r\n\r\n", "400 Bad Request", ""},
+		{"BREW http://example.com/ HTTP/1.1 extra\r\n\r\n", "400 Bad Request", ""},
+	} {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.WriteString(c, tc.request); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		c.Close()
+		if err != nil {
+			t.Errorf("%q: %v", tc.request, err)
+			continue
+		}
+		if resp.Status != tc.status || resp.Header.Get("Allow") != tc.allow {
+			t.Errorf("%q = %s with Allow %q, want %s with Allow %q", tc.request, resp.Status, resp.Header.Get("Allow"), tc.status, tc.allow)
+		}
+	}
+}
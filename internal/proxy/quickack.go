@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"context"
+	"net"
+)
+
+type quickAckListener struct {
+	net.Listener
+}
+
+func (l *quickAckListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return withQuickAck(c), nil
+}
+
+func dialWithQuickAck(dial dialer) dialer {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		c, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return withQuickAck(c), nil
+	}
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"syscall"
+)
+
+// quickAckConn sets TCP_QUICKACK after each read, because Linux may turn
+// it off again by itself.
+type quickAckConn struct {
+	*net.TCPConn
+	raw syscall.RawConn
+}
+
+func (c *quickAckConn) Read(b []byte) (int, error) {
+	n, err := c.TCPConn.Read(b)
+	setQuickAck(c.raw)
+	return n, err
+}
+
+// WriteTo hides the one promoted from *net.TCPConn, which reads without
+// setting TCP_QUICKACK, so that io.Copy from c goes through Read.
+func (c *quickAckConn) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, struct{ io.Reader }{c})
+}
+
+// withQuickAck wraps c to send ACKs immediately instead of delaying them,
+// if it's a *net.TCPConn. Connections in netstack don't support it.
+func withQuickAck(c net.Conn) net.Conn {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return c
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return c
+	}
+	setQuickAck(raw)
+	return &quickAckConn{TCPConn: tc, raw: raw}
+}
+
+func setQuickAck(raw syscall.RawConn) {
+	_ = raw.Control(func(fd uintptr) {
+		_ = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, 1)
+	})
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestQuickAck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ql := &quickAckListener{Listener: ln}
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ql.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	qc, ok := server.(*quickAckConn)
+	if !ok {
+		t.Fatalf("accepted %T, want *quickAckConn", server)
+	}
+	_, _ = client.Write([]byte("x"))
+	if _, err := qc.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	var quickAck int
+	_ = qc.raw.Control(func(fd uintptr) {
+		quickAck, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_QUICKACK)
+	})
+	if err != nil || quickAck != 1 {
+		t.Errorf("TCP_QUICKACK after read = %d, %v, want 1", quickAck, err)
+	}
+
+	_, _ = client.Write([]byte("yz"))
+	client.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, qc); err != nil || buf.String() != "yz" {
+		t.Errorf("copied %q, %v, want %q", buf.String(), err, "yz")
+	}
+}
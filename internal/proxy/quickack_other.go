@@ -0,0 +1,12 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"net"
+)
+
+// withQuickAck returns c as is, TCP_QUICKACK is only supported on Linux.
+func withQuickAck(c net.Conn) net.Conn {
+	return c
+}
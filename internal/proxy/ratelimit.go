@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// acceptMaxDelay is how long a new connection can be delayed by the
+// accept rate limit before it's dropped.
+const acceptMaxDelay = time.Second
+
+type rateLimitedListener struct {
+	net.Listener
+	limiter *rate.Limiter
+}
+
+func (l *rateLimitedListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		r := l.limiter.Reserve()
+		if d := r.Delay(); d > acceptMaxDelay {
+			r.Cancel()
+			c.Close()
+			continue
+		} else if d > 0 {
+			time.Sleep(d)
+		}
+		return c, nil
+	}
+}
+
+// bandwidth limits the total throughput of client connections in bytes per
+// second, and measures the throughput of last second.
+type bandwidth struct {
+	limiter *rate.Limiter
+
+	mu      sync.Mutex
+	second  int64
+	current int64
+	last    int64
+}
+
+func newBandwidth(limit float64, burst int) *bandwidth {
+	if burst <= 0 {
+		burst = int(math.Ceil(limit))
+	}
+	return &bandwidth{limiter: rate.NewLimiter(rate.Limit(limit), burst)}
+}
+
+// readSize returns the size to read for a buffer of size n, so that the
+// data read can be waited for at once.
+func (b *bandwidth) readSize(n int) int {
+	if burst := b.limiter.Burst(); n > burst {
+		return burst
+	}
+	return n
+}
+
+// wait blocks until n bytes can be transferred.
+func (b *bandwidth) wait(n int) {
+	b.add(n)
+	for n > 0 {
+		chunk := b.readSize(n)
+		_ = b.limiter.WaitN(context.Background(), chunk)
+		n -= chunk
+	}
+}
+
+func (b *bandwidth) add(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+	b.current += int64(n)
+}
+
+// roll moves to the current second.
+func (b *bandwidth) roll() {
+	now := time.Now().Unix()
+	switch now {
+	case b.second:
+		return
+	case b.second + 1:
+		b.last = b.current
+	default:
+		b.last = 0
+	}
+	b.second = now
+	b.current = 0
+}
+
+func (b *bandwidth) MarshalJSON() ([]byte, error) {
+	b.mu.Lock()
+	b.roll()
+	throughput := b.last
+	b.mu.Unlock()
+
+	limit := float64(b.limiter.Limit())
+	return json.Marshal(struct {
+		Limit       float64
+		Throughput  int64
+		Utilization float64
+	}{limit, throughput, float64(throughput) / limit})
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// readBodyAhead reads the request body in background while the destination
+// is dialed, instead of after it's connected. http.Server only cancels the
+// request when client closes the connection after the body is read to the
+// end, like it does for requests without body. If reading the body fails,
+// e.g. client closes the connection while sending it, the request is
+// canceled too. Requests with "Expect: 100-continue" are left as they are,
+// so that the client waits for the destination.
+func readBodyAhead(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect || r.Body == nil || r.Body == http.NoBody ||
+			r.Header.Get("Expect") != "" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		body := newAheadBody()
+		defer body.Close()
+		go func(src io.Reader) {
+			if err := body.fill(src); err != nil && err != io.EOF {
+				cancel()
+			}
+		}(r.Body)
+
+		r = r.WithContext(ctx)
+		r.Body = body
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// aheadBody is a request body read ahead in background, which buffers up
+// to relayBufferSize bytes, so that it's still streamed.
+type aheadBody struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	buf  []byte
+	// err is the error of reading the body, which is returned once buf is
+	// read.
+	err    error
+	closed bool
+}
+
+func newAheadBody() *aheadBody {
+	b := &aheadBody{}
+	b.cond.L = &b.mu
+	return b
+}
+
+func (b *aheadBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.buf) == 0 && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if len(b.buf) == 0 {
+		return 0, b.err
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	b.cond.Broadcast()
+	return n, nil
+}
+
+// Close stops reading ahead, and is called by transport once the body is
+// sent, or the request fails.
+func (b *aheadBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+	return nil
+}
+
+// fill reads src into b until it fails or b is closed, and returns the
+// error of reading, which is io.EOF once src is read to the end, or nil if
+// b is closed.
+func (b *aheadBody) fill(src io.Reader) error {
+	buf := make([]byte, relayBufferSize)
+	for {
+		b.mu.Lock()
+		for len(b.buf) >= relayBufferSize && !b.closed {
+			b.cond.Wait()
+		}
+		free := relayBufferSize - len(b.buf)
+		closed := b.closed
+		b.mu.Unlock()
+		if closed {
+			return nil
+		}
+
+		n, err := src.Read(buf[:free])
+		b.mu.Lock()
+		b.buf = append(b.buf, buf[:n]...)
+		b.err = err
+		b.cond.Broadcast()
+		b.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestAheadBody(t *testing.T) {
+	b := newAheadBody()
+	data := strings.Repeat("x", 3*relayBufferSize)
+	fillErr := make(chan error, 1)
+	go func() { fillErr <- b.fill(strings.NewReader(data)) }()
+	got, err := io.ReadAll(b)
+	if err != nil || string(got) != data {
+		t.Errorf("read %d bytes, %v, want %d bytes", len(got), err, len(data))
+	}
+	if err := <-fillErr; err != io.EOF {
+		t.Errorf("fill() = %v, want EOF", err)
+	}
+
+	// Errors of reading are returned after buffered data.
+	b = newAheadBody()
+	readErr := errors.New("unexpected EOF")
+	if err := b.fill(io.MultiReader(strings.NewReader("partial"), &errReader{readErr})); err != readErr {
+		t.Errorf("fill() = %v, want %v", err, readErr)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, b); err != readErr || buf.String() != "partial" {
+		t.Errorf("read %q, %v, want %q, %v", buf.String(), err, "partial", readErr)
+	}
+
+	// Reading ahead stops once it's closed.
+	b = newAheadBody()
+	go func() { fillErr <- b.fill(strings.NewReader(data)) }()
+	b.Close()
+	if err := <-fillErr; err != nil {
+		t.Errorf("fill() after close = %v, want nil", err)
+	}
+	if _, err := b.Read(make([]byte, 1)); err != io.ErrClosedPipe {
+		t.Errorf("Read() after close = %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
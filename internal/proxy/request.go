@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Request describes a finished HTTP proxy request, including CONNECT.
+type Request struct {
+	Client string
+	Start  time.Time
+	End    time.Time
+
+	Method string
+	URI    string
+	Proto  string
+
+	// Status is the status code responded, and Size is the bytes of
+	// response body. For CONNECT, Size is all the bytes sent to client
+	// after the request.
+	Status int
+	Size   int64
+
+	Referer   string
+	UserAgent string
+}
+
+// logRequests calls onRequest with each finished request.
+func logRequests(next http.Handler, onRequest func(Request)) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w := &responseRecorder{ResponseWriter: rw}
+		start := time.Now()
+		next.ServeHTTP(w, r)
+
+		status := w.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		onRequest(Request{
+			Client:    r.RemoteAddr,
+			Start:     start,
+			End:       time.Now(),
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Proto:     r.Proto,
+			Status:    status,
+			Size:      w.size.Load(),
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		})
+	})
+}
+
+// responseRecorder records the status and size of response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   atomic.Int64
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size.Add(int64(n))
+	return n, err
+}
+
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is used for CONNECT, where the status is written to the
+// connection directly.
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	c, rw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.status = http.StatusOK
+	return &countingConn{Conn: c, size: &w.size}, rw, nil
+}
+
+type countingConn struct {
+	net.Conn
+	size *atomic.Int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.size.Add(int64(n))
+	return n, err
+}
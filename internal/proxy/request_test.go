@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhsj/wghttp/internal/third_party/tailscale/httpproxy"
+)
+
+const (
+	streamChunk = 64 << 10
+	streamTotal = 32 << 20
+)
+
+// streamProxy returns a client which uses an HTTP proxy with request
+// logging, like the one in Proxy.Serve.
+func streamProxy(t *testing.T) *http.Client {
+	var d net.Dialer
+	proxy := httptest.NewServer(logRequests(httpproxy.Handler(d.DialContext), func(Request) {}))
+	t.Cleanup(proxy.Close)
+	proxyURL, _ := url.Parse(proxy.URL)
+	return &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+}
+
+// waitFor waits for c to be closed. It can be called in goroutines other
+// than the test.
+func waitFor(t *testing.T, c <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Errorf("timeout waiting for %s, body may be buffered", what)
+	}
+}
+
+func TestStreamRequestBody(t *testing.T) {
+	firstChunk := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n, err := io.ReadFull(r.Body, make([]byte, streamChunk))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		close(firstChunk)
+		rest, _ := io.Copy(io.Discard, r.Body)
+		_, _ = io.WriteString(rw, strconv.FormatInt(int64(n)+rest, 10))
+	}))
+	defer upstream.Close()
+
+	body, bodyWriter := io.Pipe()
+	go func() {
+		chunk := bytes.Repeat([]byte("x"), streamChunk)
+		_, _ = bodyWriter.Write(chunk)
+		waitFor(t, firstChunk, "first chunk of request body")
+		for i := streamChunk; i < streamTotal; i += streamChunk {
+			_, _ = bodyWriter.Write(chunk)
+		}
+		bodyWriter.Close()
+	}()
+
+	resp, err := streamProxy(t).Post(upstream.URL, "application/octet-stream", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, _ := io.ReadAll(resp.Body)
+	if want := strconv.Itoa(streamTotal); string(got) != want {
+		t.Errorf("upstream received %s bytes, want %s", got, want)
+	}
+}
+
+func TestStreamResponseBody(t *testing.T) {
+	firstChunk := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		chunk := bytes.Repeat([]byte("x"), streamChunk)
+		_, _ = rw.Write(chunk)
+		rw.(http.Flusher).Flush()
+		waitFor(t, firstChunk, "first chunk of response body")
+		for i := streamChunk; i < streamTotal; i += streamChunk {
+			_, _ = rw.Write(chunk)
+		}
+	}))
+	defer upstream.Close()
+
+	resp, err := streamProxy(t).Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadFull(resp.Body, make([]byte, streamChunk)); err != nil {
+		t.Fatal(err)
+	}
+	close(firstChunk)
+	rest, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := streamChunk + rest; got != streamTotal {
+		t.Errorf("client received %d bytes, want %d", got, streamTotal)
+	}
+}
+
+func TestRecorderHijackUnsupported(t *testing.T) {
+	w := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+	if _, _, err := w.Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("Hijack() = %v, want %v", err, http.ErrNotSupported)
+	}
+	if w.status != 0 {
+		t.Errorf("status = %d after failed Hijack, want unset", w.status)
+	}
+}
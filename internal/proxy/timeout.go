@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DialTimeoutHeader is the header of HTTP proxy requests, including
+// CONNECT, to set the dial timeout of the destination, like 5s or 5.
+const DialTimeoutHeader = "X-Wghttp-Dial-Timeout"
+
+type dialTimeoutKey struct{}
+
+// dialWithTimeout limits the time of dial to timeout, or the one in ctx
+// set by dialTimeoutHandler. Zero means no limit.
+func dialWithTimeout(dial dialer, timeout time.Duration) dialer {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		timeout := timeout
+		if t, ok := ctx.Value(dialTimeoutKey{}).(time.Duration); ok {
+			timeout = t
+		}
+		if timeout <= 0 {
+			return dial(ctx, network, address)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return dial(ctx, network, address)
+	}
+}
+
+// adaptiveTimeout estimates the dial timeout from the latency of recent
+// successful dials, like the retransmission timeout of TCP in RFC 6298,
+// within min and max. Dials which time out aren't counted, because they're
+// more likely to be of unreachable destinations than of congestion, and
+// shouldn't make others wait longer.
+type adaptiveTimeout struct {
+	min, max time.Duration
+
+	mu      sync.Mutex
+	srtt    time.Duration
+	rttvar  time.Duration
+	timeout time.Duration
+}
+
+func newAdaptiveTimeout(initial, min, max time.Duration) *adaptiveTimeout {
+	a := &adaptiveTimeout{min: min, max: max}
+	a.timeout = a.clamp(initial)
+	return a
+}
+
+func (a *adaptiveTimeout) clamp(d time.Duration) time.Duration {
+	if d < a.min {
+		return a.min
+	}
+	if d > a.max {
+		return a.max
+	}
+	return d
+}
+
+func (a *adaptiveTimeout) get() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.timeout
+}
+
+// observe updates the estimate with a successful dial which took d.
+func (a *adaptiveTimeout) observe(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.srtt == 0 {
+		a.srtt, a.rttvar = d, d/2
+	} else {
+		diff := a.srtt - d
+		if diff < 0 {
+			diff = -diff
+		}
+		a.rttvar = (3*a.rttvar + diff) / 4
+		a.srtt = (7*a.srtt + d) / 8
+	}
+	a.timeout = a.clamp(a.srtt + 4*a.rttvar)
+}
+
+func (a *adaptiveTimeout) MarshalJSON() ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return json.Marshal(struct {
+		Timeout float64
+		Latency float64
+		Min     float64
+		Max     float64
+	}{a.timeout.Seconds(), a.srtt.Seconds(), a.min.Seconds(), a.max.Seconds()})
+}
+
+// dialWithAdaptiveTimeout limits the time of dial like dialWithTimeout,
+// with the timeout estimated by a.
+func dialWithAdaptiveTimeout(dial dialer, a *adaptiveTimeout) dialer {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if _, ok := ctx.Value(dialTimeoutKey{}).(time.Duration); ok {
+			return dialWithTimeout(dial, 0)(ctx, network, address)
+		}
+		dctx, cancel := context.WithTimeout(ctx, a.get())
+		defer cancel()
+		start := time.Now()
+		c, err := dial(dctx, network, address)
+		if err == nil {
+			a.observe(time.Since(start))
+		}
+		return c, err
+	}
+}
+
+// dialTimeoutHandler sets the dial timeout of request from
+// DialTimeoutHeader, which is capped at max. The header is removed, so
+// it's not sent to destination. It's ignored if max is zero.
+func dialTimeoutHandler(next http.Handler, max time.Duration) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(DialTimeoutHeader)
+		r.Header.Del(DialTimeoutHeader)
+		if value == "" || max <= 0 {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		timeout, err := parseTimeout(value)
+		if err != nil || timeout <= 0 {
+			http.Error(rw, "invalid "+DialTimeoutHeader+": "+value, http.StatusBadRequest)
+			return
+		}
+		if timeout > max {
+			timeout = max
+		}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), dialTimeoutKey{}, timeout)))
+	})
+}
+
+// parseTimeout parses value as seconds, or a duration like 1m30s.
+func parseTimeout(value string) (time.Duration, error) {
+	if sec, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDialTimeoutHandler(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		code   int
+		// timeout is the dial timeout in request context, or zero if
+		// it's not set.
+		timeout time.Duration
+	}{
+		{"", http.StatusOK, 0},
+		{"5", http.StatusOK, 5 * time.Second},
+		{"0.5", http.StatusOK, 500 * time.Millisecond},
+		{"1m30s", http.StatusOK, 90 * time.Second},
+		{"1h", http.StatusOK, 2 * time.Minute},
+		{"0", http.StatusBadRequest, 0},
+		{"-1s", http.StatusBadRequest, 0},
+		{"soon", http.StatusBadRequest, 0},
+	} {
+		var timeout time.Duration
+		var header string
+		h := dialTimeoutHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			timeout, _ = r.Context().Value(dialTimeoutKey{}).(time.Duration)
+			header = r.Header.Get(DialTimeoutHeader)
+		}), 2*time.Minute)
+
+		req := httptest.NewRequest(http.MethodConnect, "example.com:443", nil)
+		if tc.header != "" {
+			req.Header.Set(DialTimeoutHeader, tc.header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.code || timeout != tc.timeout {
+			t.Errorf("%s: %q = %d with timeout %v, want %d with timeout %v", DialTimeoutHeader, tc.header, rec.Code, timeout, tc.code, tc.timeout)
+		}
+		if header != "" {
+			t.Errorf("%s: %q is passed to next handler", DialTimeoutHeader, tc.header)
+		}
+	}
+}
+
+func TestDialWithTimeout(t *testing.T) {
+	var deadline time.Time
+	dial := dialWithTimeout(func(ctx context.Context, network, address string) (net.Conn, error) {
+		deadline, _ = ctx.Deadline()
+		return nil, nil
+	}, 10*time.Second)
+
+	_, _ = dial(context.Background(), "tcp", "example.com:80")
+	if d := time.Until(deadline); d < 9*time.Second || d > 10*time.Second {
+		t.Errorf("default deadline is in %v, want 10s", d)
+	}
+	_, _ = dial(context.WithValue(context.Background(), dialTimeoutKey{}, time.Minute), "tcp", "example.com:80")
+	if d := time.Until(deadline); d < 59*time.Second || d > time.Minute {
+		t.Errorf("deadline with dial timeout in context is in %v, want 1m", d)
+	}
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	a := newAdaptiveTimeout(10*time.Second, time.Second, 30*time.Second)
+	if got := a.get(); got != 10*time.Second {
+		t.Errorf("initial timeout = %v, want 10s", got)
+	}
+
+	for i := 0; i < 20; i++ {
+		a.observe(50 * time.Millisecond)
+	}
+	if got := a.get(); got != time.Second {
+		t.Errorf("timeout after fast dials = %v, want min 1s", got)
+	}
+
+	for i := 0; i < 20; i++ {
+		a.observe(3 * time.Second)
+	}
+	if got := a.get(); got < 3*time.Second || got > 5*time.Second {
+		t.Errorf("timeout after 3s dials = %v, want about 3s", got)
+	}
+
+	for i := 0; i < 20; i++ {
+		a.observe(time.Minute)
+	}
+	if got := a.get(); got != 30*time.Second {
+		t.Errorf("timeout after 1m dials = %v, want max 30s", got)
+	}
+}
+
+func TestDialWithAdaptiveTimeout(t *testing.T) {
+	a := newAdaptiveTimeout(10*time.Millisecond, 10*time.Millisecond, time.Second)
+	var deadline time.Time
+	dial := dialWithAdaptiveTimeout(func(ctx context.Context, network, address string) (net.Conn, error) {
+		deadline, _ = ctx.Deadline()
+		if address == "dead.example.com:80" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		time.Sleep(2 * time.Millisecond)
+		return &net.TCPConn{}, nil
+	}, a)
+
+	// Repeated timeouts to one destination don't make others wait longer.
+	for i := 0; i < 5; i++ {
+		if _, err := dial(context.Background(), "tcp", "dead.example.com:80"); err == nil {
+			t.Fatal("dial doesn't time out")
+		}
+	}
+	if got := a.get(); got != 10*time.Millisecond {
+		t.Errorf("timeout after dials timed out = %v, want 10ms", got)
+	}
+	if _, err := dial(context.Background(), "tcp", "example.com:80"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(deadline); d > 10*time.Millisecond {
+		t.Errorf("deadline of other destination is in %v, want 10ms", d)
+	}
+
+	// Timeout from HTTP proxy clients is used as it is, and not observed.
+	before := a.get()
+	ctx := context.WithValue(context.Background(), dialTimeoutKey{}, 5*time.Millisecond)
+	_, _ = dial(ctx, "tcp", "dead.example.com:80")
+	if d := time.Until(deadline); d > 5*time.Millisecond {
+		t.Errorf("deadline with dial timeout in context is in %v, want 5ms", d)
+	}
+	if got := a.get(); got != before {
+		t.Errorf("timeout after dial with client timeout = %v, want %v", got, before)
+	}
+}
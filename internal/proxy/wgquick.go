@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// wgQuickHandler serves the running config as a wg-quick file at
+// /wg-quick.conf. Keys are redacted unless requested with ?keys=true, which
+// is forbidden unless allowKeys is set.
+func wgQuickHandler(next http.Handler, conf func(keys bool) (string, error), allowKeys bool) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/wg-quick.conf" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if !allowGet(rw, r) {
+			return
+		}
+		keys, _ := strconv.ParseBool(r.URL.Query().Get("keys"))
+		if keys && !allowKeys {
+			http.Error(rw, "Key export is disabled", http.StatusForbidden)
+			return
+		}
+		s, err := conf(keys)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if keys {
+			rw.Header().Set("Cache-Control", "no-store")
+		}
+		_, _ = rw.Write([]byte(s))
+	})
+}
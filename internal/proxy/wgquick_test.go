@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWGQuickHandler(t *testing.T) {
+	var confErr error
+	conf := func(keys bool) (string, error) {
+		if keys {
+			return "PrivateKey = key\n", confErr
+		}
+		return "# PrivateKey = <redacted>\n", confErr
+	}
+
+	for _, tc := range []struct {
+		target    string
+		allowKeys bool
+		confErr   error
+		code      int
+		body      string
+	}{
+		{"/wg-quick.conf", false, nil, http.StatusOK, "# PrivateKey = <redacted>\n"},
+		{"/wg-quick.conf?keys=true", false, nil, http.StatusForbidden, "Key export is disabled\n"},
+		{"/wg-quick.conf?keys=true", true, nil, http.StatusOK, "PrivateKey = key\n"},
+		{"/wg-quick.conf?keys=no", true, nil, http.StatusOK, "# PrivateKey = <redacted>\n"},
+		{"/wg-quick.conf", false, errors.New("device is down"), http.StatusInternalServerError, "device is down\n"},
+		{"/other", false, nil, http.StatusNotFound, "404 page not found\n"},
+	} {
+		confErr = tc.confErr
+		h := wgQuickHandler(http.NotFoundHandler(), conf, tc.allowKeys)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.target, nil))
+		if rec.Code != tc.code || rec.Body.String() != tc.body {
+			t.Errorf("GET %s with allow keys %v, conf error %v = %d %q, want %d %q", tc.target, tc.allowKeys, tc.confErr, rec.Code, rec.Body, tc.code, tc.body)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package resolver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// Lookup is the result of a successful lookup, which is recorded as a line
+// of JSON, like:
+//
+//	{"Network":"ip4","Host":"example.com","Addrs":["93.184.216.34"]}
+//
+// Network is ip, ip4 or ip6.
+type Lookup struct {
+	Network string
+	Host    string
+	Addrs   []netip.Addr
+}
+
+type lookupKey struct {
+	network, host string
+}
+
+// recorder writes lookups to w.
+type recorder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (rec *recorder) write(l Lookup) {
+	line, _ := json.Marshal(l)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	_, _ = rec.w.Write(append(line, '\n'))
+}
+
+// ReadLookups reads lookups recorded by Resolver.Record.
+func ReadLookups(rd io.Reader) ([]Lookup, error) {
+	lookups := []Lookup{}
+	scanner := bufio.NewScanner(rd)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var l Lookup
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		lookups = append(lookups, l)
+	}
+	return lookups, scanner.Err()
+}
+
+// Record writes each successful lookup to w.
+func (r *Resolver) Record(w io.Writer) {
+	r.recorder = &recorder{w: w}
+}
+
+// Replay makes the resolver answer from lookups only, without querying DNS
+// server. Hosts not in lookups are not found. For the same host and
+// network, the last lookup is used.
+func (r *Resolver) Replay(lookups []Lookup) {
+	r.replay = map[lookupKey][]netip.Addr{}
+	for _, l := range lookups {
+		r.replay[lookupKey{l.Network, l.Host}] = l.Addrs
+	}
+}
+
+// replayLookup finds the lookup of host in network. Lookups in other
+// networks are also used, e.g. ip4 addresses of a lookup in ip, and the
+// result is filtered by the caller.
+func (r *Resolver) replayLookup(network, host string) ([]netip.Addr, error) {
+	if ips, ok := r.replay[lookupKey{network, host}]; ok {
+		return ips, nil
+	}
+	var ips []netip.Addr
+	found := false
+	for _, n := range []string{"ip", "ip4", "ip6"} {
+		if n == network || (network != "ip" && n != "ip") {
+			continue
+		}
+		if addrs, ok := r.replay[lookupKey{n, host}]; ok {
+			ips = append(ips, addrs...)
+			found = true
+		}
+	}
+	if !found {
+		return nil, &net.DNSError{Err: "not in replay", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
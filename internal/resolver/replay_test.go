@@ -0,0 +1,47 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	recorded := `{"Network":"ip4","Host":"example.com","Addrs":["93.184.216.34"]}` + "\n"
+	lookups, err := ReadLookups(strings.NewReader(recorded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := New("", nil)
+	r.Replay(lookups)
+	var buf bytes.Buffer
+	r.Record(&buf)
+
+	ips, err := r.LookupNetIP(context.Background(), "tcp4", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []netip.Addr{netip.MustParseAddr("93.184.216.34")}; len(ips) != 1 || ips[0] != want[0] {
+		t.Errorf("replayed %v, want %v", ips, want)
+	}
+	if buf.String() != recorded {
+		t.Errorf("recorded %q, want %q", buf.String(), recorded)
+	}
+
+	// Lookup in ip uses the one in ip4.
+	ips, err = r.LookupNetIP(context.Background(), "tcp", "example.com")
+	if err != nil || len(ips) != 1 {
+		t.Errorf("replayed %v, %v for tcp, want the address for tcp4", ips, err)
+	}
+
+	_, err = r.LookupNetIP(context.Background(), "tcp6", "example.com")
+	dnsErr := &net.DNSError{}
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("lookup not in replay: %v, want not found", err)
+	}
+}
@@ -8,17 +8,44 @@ import (
 	"net/http"
 	"net/netip"
 	"strings"
+	"sync"
+	"time"
 )
 
 var errNotRetry = errors.New("not retry")
 
 type Resolver struct {
+	// Retransmits is the number of times a UDP query is resent if there's
+	// no response in RetransmitInterval. The total time is still bounded by
+	// the timeout of resolver.
+	Retransmits        int
+	RetransmitInterval time.Duration
+	// Stats counts lookups if it's not nil.
+	Stats *Stats
+
+	// sysAddr is the first DNS server of system dialed by r, which is
+	// replaced with addr. Others are not dialed, to avoid retrying.
+	sysAddrMu     sync.Mutex
 	sysAddr, addr string
 	network       string
 	tlsConfig     *tls.Config
 	httpClient    *http.Client
 
 	r *net.Resolver
+
+	// sourcePort is the fixed source port of UDP queries if it's not zero,
+	// which is held by one query at a time with portLock.
+	sourcePort uint16
+	dialUDP    DialUDP
+	portLock   chan struct{}
+
+	recorder *recorder
+	replay   map[lookupKey][]netip.Addr
+
+	// fallbacks are tried in order if a lookup fails other than not
+	// found, which is limited to fallbackTimeout then.
+	fallbacks       []*Resolver
+	fallbackTimeout time.Duration
 }
 
 func (r *Resolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
@@ -32,7 +59,90 @@ func (r *Resolver) LookupNetIP(ctx context.Context, network, host string) ([]net
 		ipNetwork = "ip6"
 	}
 
-	return r.r.LookupNetIP(ctx, ipNetwork, host)
+	var ips []netip.Addr
+	var err error
+	if r.replay != nil {
+		ips, err = r.replayLookup(ipNetwork, host)
+	} else {
+		lookupCtx := ctx
+		if len(r.fallbacks) > 0 && r.fallbackTimeout > 0 {
+			var cancel context.CancelFunc
+			lookupCtx, cancel = context.WithTimeout(ctx, r.fallbackTimeout)
+			defer cancel()
+		}
+		start := time.Now()
+		ips, err = r.r.LookupNetIP(lookupCtx, ipNetwork, host)
+		if _, ipErr := netip.ParseAddr(host); r.Stats != nil && ipErr != nil {
+			r.Stats.observe(r.protocol(), time.Since(start), err)
+		}
+		if err != nil {
+			ips, err = r.lookupFallback(ctx, ipNetwork, host, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r.recorder != nil {
+		r.recorder.write(Lookup{Network: ipNetwork, Host: host, Addrs: ips})
+	}
+	ips = filterFamily(ips, ipNetwork)
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// SetFallback sets the resolvers to try in order, if a lookup fails other
+// than not found, e.g. when the DNS server is down. Names which don't
+// exist are not looked up again, so that split DNS still works. The
+// lookup of r is limited to timeout if it's not zero, so that fallbacks
+// have time to answer.
+func (r *Resolver) SetFallback(timeout time.Duration, fallbacks ...*Resolver) {
+	r.fallbackTimeout = timeout
+	r.fallbacks = fallbacks
+}
+
+// lookupFallback looks up host with fallbacks after err of r.
+func (r *Resolver) lookupFallback(ctx context.Context, network, host string, err error) ([]netip.Addr, error) {
+	for _, fallback := range r.fallbacks {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || dnsErr.IsNotFound || ctx.Err() != nil {
+			break
+		}
+		var ips []netip.Addr
+		ips, err = fallback.r.LookupNetIP(ctx, network, host)
+		if err == nil {
+			return ips, nil
+		}
+	}
+	return nil, err
+}
+
+// protocol returns the protocol of DNS server, or system if it's not set.
+func (r *Resolver) protocol() string {
+	switch {
+	case r.httpClient != nil:
+		return "https"
+	case r.tlsConfig != nil:
+		return "tls"
+	case r.network != "":
+		return r.network
+	}
+	return "system"
+}
+
+// filterFamily returns the addresses of ips in network, which is ip, ip4
+// or ip6. IPv4-mapped IPv6 addresses are treated as IPv4.
+func filterFamily(ips []netip.Addr, network string) []netip.Addr {
+	filtered := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if (network == "ip4" && !ip.Is4()) || (network == "ip6" && !ip.Is6()) {
+			continue
+		}
+		filtered = append(filtered, ip)
+	}
+	return filtered
 }
 
 func New(dns string, dial func(ctx context.Context, network, address string) (net.Conn, error)) *Resolver {
@@ -47,10 +157,7 @@ func New(dns string, dial func(ctx context.Context, network, address string) (ne
 		r.r = &net.Resolver{
 			PreferGo: true,
 			Dial: func(ctx context.Context, _, address string) (net.Conn, error) {
-				if r.sysAddr == "" {
-					r.sysAddr = address
-				}
-				if r.sysAddr != address {
+				if !r.isSysAddr(address) {
 					return nil, errNotRetry
 				}
 				conn, err := dial(ctx, "tcp", r.addr)
@@ -61,18 +168,17 @@ func New(dns string, dial func(ctx context.Context, network, address string) (ne
 			},
 		}
 	case strings.HasPrefix(dns, "https://"):
+		r.tlsConfig = &tls.Config{}
 		r.httpClient = &http.Client{
 			Transport: &http.Transport{
-				DialContext: dial,
+				DialContext:     dial,
+				TLSClientConfig: r.tlsConfig,
 			},
 		}
 		r.r = &net.Resolver{
 			PreferGo: true,
 			Dial: func(ctx context.Context, _, address string) (net.Conn, error) {
-				if r.sysAddr == "" {
-					r.sysAddr = address
-				}
-				if r.sysAddr != address {
+				if !r.isSysAddr(address) {
 					return nil, errNotRetry
 				}
 
@@ -92,14 +198,24 @@ func New(dns string, dial func(ctx context.Context, network, address string) (ne
 		r.r = &net.Resolver{
 			PreferGo: true,
 			Dial: func(ctx context.Context, _, address string) (net.Conn, error) {
-				if r.sysAddr == "" {
-					r.sysAddr = address
-				}
-				if r.sysAddr != address {
+				if !r.isSysAddr(address) {
 					return nil, errNotRetry
 				}
 
-				return dial(ctx, r.network, r.addr)
+				var conn net.Conn
+				var err error
+				if r.sourcePort != 0 {
+					conn, err = r.dialSourcePort(ctx)
+				} else {
+					conn, err = dial(ctx, r.network, r.addr)
+				}
+				if err != nil {
+					return nil, err
+				}
+				if pc, ok := conn.(packetConn); ok && r.Retransmits > 0 && r.RetransmitInterval > 0 {
+					return &retransmitConn{packetConn: pc, retransmits: r.Retransmits, interval: r.RetransmitInterval}, nil
+				}
+				return conn, nil
 			},
 		}
 	default:
@@ -108,6 +224,42 @@ func New(dns string, dial func(ctx context.Context, network, address string) (ne
 	return r
 }
 
+func (r *Resolver) isSysAddr(address string) bool {
+	r.sysAddrMu.Lock()
+	defer r.sysAddrMu.Unlock()
+	if r.sysAddr == "" {
+		r.sysAddr = address
+	}
+	return r.sysAddr == address
+}
+
+// SetTLS restricts the TLS versions and cipher suites for DNS over TLS and
+// DNS over HTTPS. Default values are used if they're zero.
+func (r *Resolver) SetTLS(minVersion uint16, cipherSuites []uint16) {
+	if r.tlsConfig == nil {
+		return
+	}
+	r.tlsConfig.MinVersion = minVersion
+	r.tlsConfig.CipherSuites = cipherSuites
+}
+
+// ServerIP returns the IP address of DNS server in dns, if it's an IP
+// address rather than a domain.
+func ServerIP(dns string) (netip.Addr, bool) {
+	host := dns
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+len("://"):]
+	}
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		host = host[:i]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	return ip, err == nil
+}
+
 func withDefaultPort(addr, port string) string {
 	if _, _, err := net.SplitHostPort(addr); err == nil {
 		return addr
@@ -3,7 +3,10 @@ package resolver
 import (
 	"context"
 	"net"
+	"net/netip"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestResolve(t *testing.T) {
@@ -37,3 +40,215 @@ func TestResolve(t *testing.T) {
 		})
 	}
 }
+
+func TestRetransmit(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	// Drop the first query of each name, and answer the later ones.
+	go func() {
+		seen := map[string]bool{}
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := string(buf[12:n])
+			if !seen[query] {
+				seen[query] = true
+				continue
+			}
+			// Keep the header and question only.
+			end := 12
+			for buf[end] != 0 {
+				end += int(buf[end]) + 1
+			}
+			resp := append([]byte{}, buf[:end+5]...)
+			resp[2] |= 0x80                           // QR
+			resp[7] = 1                               // ANCOUNT
+			resp[10], resp[11] = 0, 0                 // ARCOUNT
+			resp = append(resp, 0xc0, 12, 0, 1, 0, 1) // name, type A, class IN
+			resp = append(resp, 0, 0, 0, 60, 0, 4)    // TTL, RDLENGTH
+			resp = append(resp, 192, 0, 2, 1)         // RDATA
+			_, _ = pc.WriteTo(resp, addr)
+		}
+	}()
+
+	r := New(pc.LocalAddr().String(), (&net.Dialer{}).DialContext)
+	r.Retransmits = 1
+	r.RetransmitInterval = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ips, err := r.LookupNetIP(ctx, "ip4", "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || ips[0].String() != "192.0.2.1" {
+		t.Errorf("got %s, want 192.0.2.1", ips)
+	}
+}
+
+func TestServerIP(t *testing.T) {
+	for dns, want := range map[string]string{
+		"":                            "",
+		"223.5.5.5":                   "223.5.5.5",
+		"udp://223.5.5.5:53":          "223.5.5.5",
+		"tcp://[2400:3200::1]:53":     "2400:3200::1",
+		"fe80::1%eth0":                "fe80::1%eth0",
+		"[fe80::1%eth0]:53":           "fe80::1%eth0",
+		"tls://dns.alidns.com":        "",
+		"https://223.5.5.5/dns-query": "223.5.5.5",
+	} {
+		ip, ok := ServerIP(dns)
+		got := ""
+		if ok {
+			got = ip.String()
+		}
+		if got != want {
+			t.Errorf("ServerIP(%q) = %q, want %q", dns, got, want)
+		}
+	}
+}
+
+func TestFilterFamily(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("::ffff:192.0.2.2"),
+	}
+	for network, want := range map[string][]string{
+		"ip":  {"192.0.2.1", "2001:db8::1", "192.0.2.2"},
+		"ip4": {"192.0.2.1", "192.0.2.2"},
+		"ip6": {"2001:db8::1"},
+	} {
+		got := []string{}
+		for _, ip := range filterFamily(ips, network) {
+			got = append(got, ip.String())
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("filterFamily(%s) = %v, want %v", network, got, want)
+		}
+	}
+}
+
+// fakeDNS serves DNS over UDP, which answers A queries with 192.0.2.1 and
+// others with no answer. If notFound is set, every name is not found. The
+// source ports of queries are sent to the returned channel.
+func fakeDNS(t *testing.T, notFound bool) (string, <-chan int) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	ports := make(chan int, 10)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case ports <- addr.(*net.UDPAddr).Port:
+			default:
+			}
+			end := 12
+			for end < n && buf[end] != 0 {
+				end += int(buf[end]) + 1
+			}
+			resp := append([]byte{}, buf[:end+5]...)
+			resp[2] |= 0x80           // QR
+			resp[3] |= 0x80           // RA
+			resp[10], resp[11] = 0, 0 // ARCOUNT
+			switch {
+			case notFound:
+				resp[3] |= 3 // NXDOMAIN
+			case resp[end+2] == 1:
+				resp[7] = 1                                            // ANCOUNT
+				resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60) // name, type A, class IN, TTL
+				resp = append(resp, 0, 4, 192, 0, 2, 1)                // RDLENGTH, RDATA
+			}
+			_, _ = pc.WriteTo(resp, addr)
+		}
+	}()
+	return pc.LocalAddr().String(), ports
+}
+
+func TestSourcePort(t *testing.T) {
+	server, ports := fakeDNS(t, false)
+
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(probe.LocalAddr().(*net.UDPAddr).Port)
+	probe.Close()
+
+	r := New(server, (&net.Dialer{}).DialContext)
+	r.SetSourcePort(port, func(ctx context.Context, laddr, raddr netip.AddrPort) (net.Conn, error) {
+		d := net.Dialer{LocalAddr: net.UDPAddrFromAddrPort(laddr)}
+		return d.DialContext(ctx, "udp", raddr.String())
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	// A and AAAA are queried concurrently, which must not fail with the
+	// port in use.
+	ips, err := r.LookupNetIP(ctx, "ip", "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || ips[0].String() != "192.0.2.1" {
+		t.Errorf("got %s, want 192.0.2.1", ips)
+	}
+	for len(ports) > 0 {
+		if got := <-ports; got != int(port) {
+			t.Errorf("query from port %d, want %d", got, port)
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	// The down server never responds.
+	down, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Close()
+	notFound, _ := fakeDNS(t, true)
+	fallback, queries := fakeDNS(t, false)
+
+	for _, tc := range []struct {
+		name, server string
+		wantErr      bool
+		wantQueries  bool
+	}{
+		{"down", down.LocalAddr().String(), false, true},
+		{"not found", notFound, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for len(queries) > 0 {
+				<-queries
+			}
+			dial := (&net.Dialer{}).DialContext
+			r := New(tc.server, dial)
+			r.SetFallback(200*time.Millisecond, New(fallback, dial))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			ips, err := r.LookupNetIP(ctx, "ip4", "www.example.com")
+			if tc.wantErr != (err != nil) {
+				t.Errorf("got %s, %v", ips, err)
+			}
+			if got := len(queries) > 0; got != tc.wantQueries {
+				t.Errorf("fallback queried: %t, want %t", got, tc.wantQueries)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package resolver
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// packetConn is a connected UDP conn. net.Resolver only uses DNS over UDP
+// if it's also a net.PacketConn.
+type packetConn interface {
+	net.Conn
+	net.PacketConn
+}
+
+var _ packetConn = &retransmitConn{}
+
+// retransmitConn resends the last query if there's no response in interval.
+type retransmitConn struct {
+	packetConn
+
+	retransmits int
+	interval    time.Duration
+
+	query    []byte
+	deadline time.Time
+}
+
+func (c *retransmitConn) Write(b []byte) (int, error) {
+	c.query = append(c.query[:0], b...)
+	return c.packetConn.Write(b)
+}
+
+func (c *retransmitConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.packetConn.SetDeadline(t)
+}
+
+func (c *retransmitConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return c.packetConn.SetReadDeadline(t)
+}
+
+func (c *retransmitConn) Read(b []byte) (int, error) {
+	for i := 0; ; i++ {
+		if i >= c.retransmits || len(c.query) == 0 {
+			if err := c.packetConn.SetReadDeadline(c.deadline); err != nil {
+				return 0, err
+			}
+			return c.packetConn.Read(b)
+		}
+
+		d := time.Now().Add(c.interval)
+		if !c.deadline.IsZero() && c.deadline.Before(d) {
+			d = c.deadline
+		}
+		if err := c.packetConn.SetReadDeadline(d); err != nil {
+			return 0, err
+		}
+		n, err := c.packetConn.Read(b)
+		var netErr net.Error
+		if err == nil || !errors.As(err, &netErr) || !netErr.Timeout() || d == c.deadline {
+			return n, err
+		}
+
+		if _, err := c.packetConn.Write(c.query); err != nil {
+			return 0, err
+		}
+	}
+}
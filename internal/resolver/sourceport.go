@@ -0,0 +1,66 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// DialUDP dials a UDP connection from laddr to raddr. The address of laddr
+// may be unspecified.
+type DialUDP func(ctx context.Context, laddr, raddr netip.AddrPort) (net.Conn, error)
+
+// SetSourcePort fixes the source port of queries for DNS over UDP, which
+// are dialed by dial. Only one query can use the port at a time, so
+// queries are sent one after another. It's ignored for other protocols.
+func (r *Resolver) SetSourcePort(port uint16, dial DialUDP) {
+	if r.network != "udp" || port == 0 {
+		return
+	}
+	r.sourcePort = port
+	r.dialUDP = dial
+	r.portLock = make(chan struct{}, 1)
+}
+
+// dialSourcePort dials the DNS server from the fixed source port, and
+// holds the port until the connection is closed.
+func (r *Resolver) dialSourcePort(ctx context.Context) (net.Conn, error) {
+	raddr, err := netip.ParseAddrPort(r.addr)
+	if err != nil {
+		return nil, fmt.Errorf("fixed source port requires IP address of DNS server: %w", err)
+	}
+	select {
+	case r.portLock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-r.portLock }
+
+	conn, err := r.dialUDP(ctx, netip.AddrPortFrom(netip.Addr{}, r.sourcePort), raddr)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	pc, ok := conn.(packetConn)
+	if !ok {
+		conn.Close()
+		release()
+		return nil, fmt.Errorf("dial UDP returns %T", conn)
+	}
+	return &sourcePortConn{packetConn: pc, release: release}, nil
+}
+
+// sourcePortConn releases the source port when it's closed.
+type sourcePortConn struct {
+	packetConn
+	release func()
+	once    sync.Once
+}
+
+func (c *sourcePortConn) Close() error {
+	err := c.packetConn.Close()
+	c.once.Do(c.release)
+	return err
+}
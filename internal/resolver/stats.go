@@ -0,0 +1,83 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of lookup latency histogram.
+var latencyBuckets = [...]time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// Stats counts lookups of Resolver. Lookups of IP addresses and lookups
+// answered by Replay are not counted.
+type Stats struct {
+	protocol atomic.Value
+
+	lookups  atomic.Int64
+	notFound atomic.Int64
+	timeouts atomic.Int64
+	errors   atomic.Int64
+	// latency is indexed by the bucket of latencyBuckets, and the last
+	// one is for lookups slower than all buckets.
+	latency [len(latencyBuckets) + 1]atomic.Int64
+}
+
+func (s *Stats) observe(protocol string, d time.Duration, err error) {
+	s.protocol.Store(protocol)
+	s.lookups.Add(1)
+
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		s.notFound.Add(1)
+	case errors.As(err, &dnsErr) && dnsErr.IsTimeout, errors.Is(err, context.DeadlineExceeded):
+		s.timeouts.Add(1)
+	default:
+		s.errors.Add(1)
+	}
+
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	s.latency[i].Add(1)
+}
+
+// MarshalJSON encodes the latency histogram as cumulative counts of
+// lookups, keyed by the upper bounds in seconds, like Prometheus.
+func (s *Stats) MarshalJSON() ([]byte, error) {
+	protocol, _ := s.protocol.Load().(string)
+	latency := map[string]int64{}
+	var count int64
+	for i := range s.latency {
+		count += s.latency[i].Load()
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = strconv.FormatFloat(latencyBuckets[i].Seconds(), 'f', -1, 64)
+		}
+		latency[le] = count
+	}
+	return json.Marshal(struct {
+		Protocol string
+		Lookups  int64
+		NotFound int64
+		Timeouts int64
+		Errors   int64
+		Latency  map[string]int64
+	}{protocol, s.lookups.Load(), s.notFound.Load(), s.timeouts.Load(), s.errors.Load(), latency})
+}
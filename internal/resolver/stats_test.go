@@ -0,0 +1,38 @@
+package resolver
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	s := &Stats{}
+	s.observe("udp", 5*time.Millisecond, nil)
+	s.observe("udp", 300*time.Millisecond, &net.DNSError{Err: "no such host", IsNotFound: true})
+	s.observe("udp", 10*time.Second, &net.DNSError{Err: "i/o timeout", IsTimeout: true})
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Protocol                            string
+		Lookups, NotFound, Timeouts, Errors int64
+		Latency                             map[string]int64
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Protocol != "udp" || got.Lookups != 3 || got.NotFound != 1 || got.Timeouts != 1 || got.Errors != 0 {
+		t.Errorf("got %s", b)
+	}
+	wantLatency := map[string]int64{
+		"0.01": 1, "0.05": 1, "0.1": 1, "0.25": 1, "0.5": 2, "1": 2, "2.5": 2, "5": 2, "+Inf": 3,
+	}
+	if !reflect.DeepEqual(got.Latency, wantLatency) {
+		t.Errorf("latency = %v, want %v", got.Latency, wantLatency)
+	}
+}
@@ -8,27 +8,47 @@ package httpproxy
 
 import (
 	"context"
+	"errors"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"os"
 	"strings"
 )
 
 // Handler returns an HTTP proxy http.Handler using the
 // provided backend dialer.
 func Handler(dialer func(ctx context.Context, netw, addr string) (net.Conn, error)) http.Handler {
+	return HandlerWithTransport(&http.Transport{DialContext: dialer}, nil)
+}
+
+// HandlerWithTransport returns an HTTP proxy http.Handler using the
+// provided transport for requests, which pools backend connections. Its
+// DialContext is also used for CONNECT, and data of CONNECT is relayed
+// with copy, or io.Copy if it's nil.
+func HandlerWithTransport(tr *http.Transport, copy func(dst io.Writer, src io.Reader) (int64, error)) http.Handler {
+	if copy == nil {
+		copy = io.Copy
+	}
+	dialer := tr.DialContext
 	rp := &httputil.ReverseProxy{
-		Director: func(r *http.Request) {}, // no change
-		Transport: &http.Transport{
-			DialContext: dialer,
+		Director:  func(r *http.Request) {}, // no change
+		Transport: tr,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("http: proxy error: %v", err)
+			w.WriteHeader(errorStatus(err, http.StatusBadGateway))
 		},
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "CONNECT" {
 			backURL := r.RequestURI
 			if strings.HasPrefix(backURL, "/") || backURL == "*" {
-				http.Error(w, "bogus RequestURI; must be absolute URL or CONNECT", 400)
+				// Not a proxy request, which is either CONNECT or with an
+				// absolute URL.
+				w.Header().Set("Allow", "CONNECT")
+				http.Error(w, "method not allowed; proxy requests must be CONNECT or with absolute URL", http.StatusMethodNotAllowed)
 				return
 			}
 			rp.ServeHTTP(w, r)
@@ -41,7 +61,7 @@ func Handler(dialer func(ctx context.Context, netw, addr string) (net.Conn, erro
 		c, err := dialer(r.Context(), "tcp", dst)
 		if err != nil {
 			w.Header().Set("Connect-Error", err.Error())
-			http.Error(w, err.Error(), 500)
+			http.Error(w, err.Error(), errorStatus(err, 500))
 			return
 		}
 		defer c.Close()
@@ -67,13 +87,26 @@ func Handler(dialer func(ctx context.Context, netw, addr string) (net.Conn, erro
 
 		errc := make(chan error, 1)
 		go func() {
-			_, err := io.Copy(cc, c)
+			_, err := copy(cc, c)
 			errc <- err
 		}()
 		go func() {
-			_, err := io.Copy(c, clientSrc)
+			_, err := copy(c, clientSrc)
 			errc <- err
 		}()
 		<-errc
 	})
 }
+
+// errorStatus returns the status code for a failed dial, or code if
+// there's no more specific one.
+func errorStatus(err error, code int) int {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return http.StatusForbidden
+	}
+	return code
+}
@@ -14,12 +14,16 @@
 package socks5
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/netip"
+	"os"
 	"strconv"
 	"time"
 )
@@ -82,6 +86,21 @@ type Server struct {
 	// Dialer optionally specifies the dialer to use for outgoing connections.
 	// If nil, the net package's standard dialer is used.
 	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// ConnContext optionally specifies a function that modifies
+	// the context used for dialing for a new connection c.
+	ConnContext func(ctx context.Context, c net.Conn) context.Context
+
+	// Copy optionally specifies the function to relay data between
+	// the client and backend. If nil, io.Copy is used.
+	Copy func(dst io.Writer, src io.Reader) (int64, error)
+}
+
+func (s *Server) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if s.Copy == nil {
+		return io.Copy(dst, src)
+	}
+	return s.Copy(dst, src)
 }
 
 func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -146,6 +165,9 @@ func (c *Conn) handleRequest() error {
 	req, err := parseClientRequest(c.clientConn)
 	if err != nil {
 		res := &response{reply: generalFailure}
+		if errors.Is(err, errAddrType) {
+			res.reply = addrTypeNotSupported
+		}
 		buf, _ := res.marshal()
 		c.clientConn.Write(buf)
 		return err
@@ -158,42 +180,27 @@ func (c *Conn) handleRequest() error {
 	}
 	c.request = req
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx := context.Background()
+	if c.srv.ConnContext != nil {
+		ctx = c.srv.ConnContext(ctx, c.clientConn)
+	}
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	stopWatch := watchClose(c.clientConn, cancel)
 	srv, err := c.srv.dial(
 		ctx,
 		"tcp",
 		net.JoinHostPort(c.request.destination, strconv.Itoa(int(c.request.port))),
 	)
+	early := stopWatch()
 	if err != nil {
-		res := &response{reply: generalFailure}
+		res := &response{reply: errorReply(err)}
 		buf, _ := res.marshal()
 		c.clientConn.Write(buf)
 		return err
 	}
 	defer srv.Close()
-	serverAddr, serverPortStr, err := net.SplitHostPort(srv.LocalAddr().String())
-	if err != nil {
-		return err
-	}
-	serverPort, _ := strconv.Atoi(serverPortStr)
-
-	var bindAddrType addrType
-	if ip := net.ParseIP(serverAddr); ip != nil {
-		if ip.To4() != nil {
-			bindAddrType = ipv4
-		} else {
-			bindAddrType = ipv6
-		}
-	} else {
-		bindAddrType = domainName
-	}
-	res := &response{
-		reply:        success,
-		bindAddrType: bindAddrType,
-		bindAddr:     serverAddr,
-		bindPort:     uint16(serverPort),
-	}
+	res := bindResponse(srv.LocalAddr())
 	buf, err := res.marshal()
 	if err != nil {
 		res = &response{reply: generalFailure}
@@ -203,14 +210,18 @@ func (c *Conn) handleRequest() error {
 
 	errc := make(chan error, 2)
 	go func() {
-		_, err := io.Copy(c.clientConn, srv)
+		_, err := c.srv.copy(c.clientConn, srv)
 		if err != nil {
 			err = fmt.Errorf("from backend to client: %w", err)
 		}
 		errc <- err
 	}()
 	go func() {
-		_, err := io.Copy(srv, c.clientConn)
+		var clientSrc io.Reader = c.clientConn
+		if len(early) > 0 {
+			clientSrc = io.MultiReader(bytes.NewReader(early), c.clientConn)
+		}
+		_, err := c.srv.copy(srv, clientSrc)
 		if err != nil {
 			err = fmt.Errorf("from client to backend: %w", err)
 		}
@@ -219,6 +230,75 @@ func (c *Conn) handleRequest() error {
 	return <-errc
 }
 
+// bindResponse returns the success response with the local address of
+// the upstream connection as BND.ADDR and BND.PORT. The zone of IPv6
+// address is dropped, and IPv4-mapped IPv6 address is sent as IPv4.
+func bindResponse(addr net.Addr) *response {
+	var ap netip.AddrPort
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		ap = tcpAddr.AddrPort()
+	} else if addr != nil {
+		ap, _ = netip.ParseAddrPort(addr.String())
+	}
+	ip := ap.Addr().Unmap().WithZone("")
+	if !ip.IsValid() {
+		ip = netip.IPv4Unspecified()
+	}
+
+	res := &response{
+		reply:        success,
+		bindAddrType: ipv6,
+		bindAddr:     ip.String(),
+		bindPort:     ap.Port(),
+	}
+	if ip.Is4() {
+		res.bindAddrType = ipv4
+	}
+	return res
+}
+
+// watchClose calls cancel if the client closes the connection while
+// the request is in progress, like net/http does with background reads.
+// The returned function stops watching, and returns the data which the
+// client has sent early meanwhile.
+func watchClose(c net.Conn, cancel context.CancelFunc) func() []byte {
+	done := make(chan []byte, 1)
+	go func() {
+		var b [1]byte
+		n, err := c.Read(b[:])
+		var netErr net.Error
+		if n == 0 && err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			cancel()
+		}
+		done <- b[:n]
+	}()
+	return func() []byte {
+		// Unblock the pending read.
+		c.SetReadDeadline(time.Unix(1, 0))
+		b := <-done
+		c.SetReadDeadline(time.Time{})
+		return b
+	}
+}
+
+// errorReply returns the reply code for a failed dial.
+func errorReply(err error) replyCode {
+	// Domains which can't be resolved, including by timeout.
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return hostUnreachable
+	}
+	// Destinations which can't be connected in --dial-timeout.
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return hostUnreachable
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return connectionNotAllowed
+	}
+	return generalFailure
+}
+
 // parseClientGreeting parses a request initiation packet
 // and returns a slice that contains the acceptable auth methods
 // for the client.
@@ -254,6 +334,8 @@ type request struct {
 	destAddrType addrType
 }
 
+var errAddrType = errors.New("unsupported address type")
+
 // parseClientRequest converts raw packet bytes into a
 // SOCKS5Request struct.
 func parseClientRequest(r io.Reader) (*request, error) {
@@ -296,7 +378,7 @@ func parseClientRequest(r io.Reader) (*request, error) {
 		}
 		destination = net.IP(ip[:]).String()
 	} else {
-		return nil, fmt.Errorf("unsupported address type")
+		return nil, errAddrType
 	}
 	var portBytes [2]byte
 	_, err = io.ReadFull(r, portBytes[:])
@@ -326,6 +408,9 @@ type response struct {
 // marshal converts a SOCKS5Response struct into
 // a packet. If res.reply == Success, it may throw an error on
 // receiving an invalid bind address. Otherwise, it will not throw.
+//
+// Failure replies still have BND.ADDR and BND.PORT as RFC 1928 requires,
+// which are 0.0.0.0:0, since some clients reject shorter replies.
 func (res *response) marshal() ([]byte, error) {
 	pkt := make([]byte, 4)
 	pkt[0] = socks5Version
@@ -334,7 +419,8 @@ func (res *response) marshal() ([]byte, error) {
 	pkt[3] = byte(res.bindAddrType)
 
 	if res.reply != success {
-		return pkt, nil
+		pkt[3] = byte(ipv4)
+		return append(pkt, 0, 0, 0, 0, 0, 0), nil
 	}
 
 	var addr []byte
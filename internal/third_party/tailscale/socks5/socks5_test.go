@@ -0,0 +1,199 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestBindResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		addr net.Addr
+		want []byte
+	}{
+		{
+			"ipv4",
+			&net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 0x1234},
+			[]byte{5, 0, 0, 1, 10, 0, 0, 2, 0x12, 0x34},
+		},
+		{
+			"ipv4-mapped",
+			&net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.2"), Port: 80},
+			[]byte{5, 0, 0, 1, 10, 0, 0, 2, 0, 80},
+		},
+		{
+			"ipv6 with zone",
+			&net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 80, Zone: "eth0"},
+			[]byte{5, 0, 0, 4, 0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 80},
+		},
+		{
+			"unknown",
+			nil,
+			[]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := bindResponse(tc.addr).marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConnectReply(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		c, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _ = io.Copy(c, c)
+	}()
+
+	upstream := make(chan net.Conn, 1)
+	srv := &Server{
+		Logf: t.Logf,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err == nil {
+				upstream <- c
+			}
+			return c, err
+		},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	port := backend.Addr().(*net.TCPAddr).Port
+	req := []byte{5, 1, 0, 5, 1, 0, 1, 127, 0, 0, 1, byte(port >> 8), byte(port)}
+	if _, err := client.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 2+10)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	local := (<-upstream).LocalAddr().(*net.TCPAddr)
+	want := []byte{5, 0, 5, 0, 0, 1, 127, 0, 0, 1, byte(local.Port >> 8), byte(local.Port)}
+	if !bytes.Equal(reply, want) {
+		t.Errorf("got %v, want %v", reply, want)
+	}
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(client, echo); err != nil || string(echo) != "ping" {
+		t.Errorf("echo = %q, %v", echo, err)
+	}
+}
+
+func TestParseClientRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		req         []byte
+		destination string
+		addrType    addrType
+	}{
+		{
+			"ipv4",
+			[]byte{5, 1, 0, 1, 10, 0, 0, 1, 0, 80},
+			"10.0.0.1",
+			ipv4,
+		},
+		{
+			"ipv6",
+			[]byte{5, 1, 0, 4, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 80},
+			"fd00::1",
+			ipv6,
+		},
+		{
+			"domain",
+			append(append([]byte{5, 1, 0, 3, 11}, "example.com"...), 0, 80),
+			"example.com",
+			domainName,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := parseClientRequest(bytes.NewReader(tc.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.destination != tc.destination || req.port != 80 || req.destAddrType != tc.addrType {
+				t.Errorf("got %s:%d (type %d), want %s:80 (type %d)", req.destination, req.port, req.destAddrType, tc.destination, tc.addrType)
+			}
+		})
+	}
+
+	_, err := parseClientRequest(bytes.NewReader([]byte{5, 1, 0, 2, 0, 0}))
+	if !errors.Is(err, errAddrType) {
+		t.Errorf("unknown address type: %v, want %v", err, errAddrType)
+	}
+}
+
+func TestFailureReply(t *testing.T) {
+	for _, reply := range []replyCode{generalFailure, commandNotSupported, addrTypeNotSupported} {
+		got, err := (&response{reply: reply}).marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []byte{5, byte(reply), 0, 1, 0, 0, 0, 0, 0, 0}
+		if !bytes.Equal(got, want) {
+			t.Errorf("reply %d: got %v, want %v", reply, got, want)
+		}
+	}
+}
+
+func TestErrorReply(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want replyCode
+	}{
+		{errors.New("dial failed"), generalFailure},
+		{os.ErrDeadlineExceeded, hostUnreachable},
+		{fmt.Errorf("dial: %w", os.ErrPermission), connectionNotAllowed},
+		{&net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}, hostUnreachable},
+		{&net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}, hostUnreachable},
+	} {
+		if got := errorReply(tc.err); got != tc.want {
+			t.Errorf("errorReply(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestDomainReply(t *testing.T) {
+	got, err := (&response{reply: success, bindAddrType: domainName, bindAddr: "example.com", bindPort: 80}).marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte{5, 0, 0, 3, 11}, "example.com"...), 0, 80)
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
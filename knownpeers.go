@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// checkKnownPeers checks that the peer key is pinned for the peer endpoint
+// host in --known-peers file.
+//
+// Each line of the file is a host and a public key in base64, separated by
+// spaces. The host can be * to match any host. Empty lines and lines
+// starting with # are ignored.
+func (t *tunnel) checkKnownPeers() error {
+	if t.opts.KnownPeers == "" {
+		return nil
+	}
+	f, err := os.Open(t.opts.KnownPeers)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	host := t.opts.PeerEndpoint.host
+	hostKnown := false
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expect host and key", t.opts.KnownPeers, n)
+		}
+		var key keyT
+		if err := key.UnmarshalFlag(fields[1]); err != nil || len(key) != 64 {
+			return fmt.Errorf("%s:%d: invalid key %q", t.opts.KnownPeers, n, fields[1])
+		}
+		if fields[0] != host && fields[0] != "*" {
+			continue
+		}
+		if key == t.opts.PeerKey {
+			return nil
+		}
+		hostKnown = hostKnown || fields[0] == host
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if hostKnown {
+		return fmt.Errorf("peer key of %s doesn't match %s, it may have changed or the endpoint is wrong", host, t.opts.KnownPeers)
+	}
+	return fmt.Errorf("%s is not in %s", host, t.opts.KnownPeers)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckKnownPeers(t *testing.T) {
+	const (
+		peerKey  = "QST67iZm1OWeMtHlPMWLho5M/ddHtHmNOGdRGrWd/zU="
+		otherKey = "GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU="
+	)
+	var key keyT
+	if err := key.UnmarshalFlag(peerKey); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		lines []string
+		// err is a substring of the error, or empty if it's accepted.
+		err string
+	}{
+		{"host", []string{"demo.example.com " + peerKey}, ""},
+		{"wildcard", []string{"* " + peerKey}, ""},
+		{"comments and tabs", []string{"", "# pinned", "  demo.example.com\t" + peerKey + "  "}, ""},
+		{"one of keys", []string{"demo.example.com " + otherKey, "demo.example.com " + peerKey}, ""},
+		{"changed key", []string{"demo.example.com " + otherKey}, "doesn't match"},
+		{"wildcard of other key", []string{"* " + otherKey}, "is not in"},
+		{"other host", []string{"other.example.com " + peerKey}, "is not in"},
+		{"empty", nil, "is not in"},
+		{"missing key", []string{"demo.example.com"}, ":1: expect host and key"},
+		{"extra field", []string{"# ok", "demo.example.com " + peerKey + " extra"}, ":2: expect host and key"},
+		{"invalid key", []string{"demo.example.com notakey"}, `:1: invalid key "notakey"`},
+		{"short key", []string{"demo.example.com AAAA"}, `:1: invalid key "AAAA"`},
+		// Lines after the matching one are not read.
+		{"invalid after match", []string{"demo.example.com " + peerKey, "demo.example.com"}, ""},
+	} {
+		file := filepath.Join(t.TempDir(), "known_peers")
+		if err := os.WriteFile(file, []byte(strings.Join(tc.lines, "\n")), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		tn := &tunnel{}
+		tn.opts.KnownPeers = file
+		tn.opts.PeerEndpoint = hostPortT{host: "demo.example.com", port: 51820}
+		tn.opts.PeerKey = key
+
+		err := tn.checkKnownPeers()
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: error %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
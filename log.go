@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"log"
+	"sync/atomic"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// handshakeEvents maps device log formats to handshake events, which are
+// logged instead of the original messages.
+var handshakeEvents = map[string]string{
+	"%v - Sending handshake initiation":                                        "initiation sent",
+	"%v - Received handshake response":                                         "response received, session established",
+	"%v - Received handshake initiation":                                       "initiation received",
+	"%v - Sending handshake response":                                          "response sent, session established",
+	"%s - Handshake did not complete after %d seconds, retrying (try %d)":      "no response, retrying (wrong endpoint?)",
+	"%s - Handshake did not complete after %d attempts, giving up":             "no response, giving up (wrong endpoint?)",
+	"%s - Retrying handshake because we stopped hearing back after %d seconds": "peer stopped responding, retrying",
+	"Received invalid response message from %s":                                "invalid response (wrong keys?)",
+	// Servers under load reply to handshake initiation with cookie, and
+	// only accept the next initiation with it.
+	"Receiving cookie response from %s":         "cookie reply received, server is under load, retrying later",
+	"Could not decrypt invalid cookie response": "invalid cookie reply (wrong peer key?)",
+}
+
+// newLogger returns a logger writing to out, which logs verbose messages
+// only when verbose is true, so it can be toggled at runtime.
+func newLogger(out io.Writer, verbose *atomic.Bool, prefix string) *device.Logger {
+	logf := func(level string) func(string, ...any) {
+		return log.New(out, level+": "+prefix, log.Ldate|log.Ltime).Printf
+	}
+	verbosef := logf("DEBUG")
+	return &device.Logger{
+		Verbosef: func(format string, args ...any) {
+			if !verbose.Load() {
+				return
+			}
+			event, ok := handshakeEvents[format]
+			switch {
+			case !ok:
+				verbosef(format, args...)
+			case len(args) > 0:
+				verbosef("Handshake %s: %v", event, args[0])
+			default:
+				verbosef("Handshake %s", event)
+			}
+		},
+		Errorf: logf("ERROR"),
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLoggerHandshakeEvents(t *testing.T) {
+	var out bytes.Buffer
+	var verbose atomic.Bool
+	verbose.Store(true)
+	logger := newLogger(&out, &verbose, "")
+
+	logger.Verbosef("%v - Sending handshake initiation", "peer(AbCd…wXyZ)")
+	logger.Verbosef("Could not decrypt invalid cookie response")
+	logger.Verbosef("Interface state was %s, requested %s, now %s", "Down", "Up", "Up")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"Handshake initiation sent: peer(AbCd…wXyZ)",
+		"Handshake invalid cookie reply (wrong peer key?)",
+		"Interface state was Down, requested Up, now Up",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("logged %q, want one line for each message", lines)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "DEBUG: ") || !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d = %q, want %q", i, line, want[i])
+		}
+	}
+
+	out.Reset()
+	verbose.Store(false)
+	logger.Verbosef("%v - Sending handshake initiation", "peer(AbCd…wXyZ)")
+	if out.Len() != 0 {
+		t.Errorf("logged %q without verbose", out.String())
+	}
+}
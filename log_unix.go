@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// toggleVerbose toggles verbose logging of tunnels on SIGUSR2.
+func toggleVerbose(tunnels []*tunnel) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+	for range c {
+		for _, t := range tunnels {
+			verbose := !t.verbose.Load()
+			t.verbose.Store(verbose)
+			state := "off"
+			if verbose {
+				state = "on"
+			}
+			log.New(t.logOut, "INFO: "+t.logPrefix(), log.Ldate|log.Ltime).Printf("Verbose logging is %s", state)
+		}
+	}
+}
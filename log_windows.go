@@ -0,0 +1,4 @@
+package main
+
+// toggleVerbose does nothing, as there's no SIGUSR2 on Windows.
+func toggleVerbose(tunnels []*tunnel) {}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// logBuffer buffers writes to w, which are flushed every interval, or
+// when half of the buffer is filled. Writes never block on w. If the
+// buffer is full, they're dropped and counted instead.
+type logBuffer struct {
+	w        io.Writer
+	size     int
+	interval time.Duration
+	// onDrop is called with the number of writes dropped since last flush.
+	onDrop func(dropped int)
+
+	mu      sync.Mutex
+	buf     []byte
+	dropped int
+	// flushMu serializes flushes, so that lines are written in order.
+	flushMu sync.Mutex
+	wake    chan struct{}
+}
+
+func newLogBuffer(w io.Writer, size int, interval time.Duration, onDrop func(int)) *logBuffer {
+	b := &logBuffer{
+		w:        w,
+		size:     size,
+		interval: interval,
+		onDrop:   onDrop,
+		buf:      make([]byte, 0, size),
+		wake:     make(chan struct{}, 1),
+	}
+	go b.run()
+	return b
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.buf)+len(p) > b.size {
+		b.dropped++
+		return len(p), nil
+	}
+	b.buf = append(b.buf, p...)
+	if len(b.buf) >= b.size/2 {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+func (b *logBuffer) run() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.wake:
+		}
+		b.Flush()
+	}
+}
+
+// Flush writes the buffered data to w.
+func (b *logBuffer) Flush() {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	buf, dropped := b.buf, b.dropped
+	b.buf, b.dropped = make([]byte, 0, b.size), 0
+	b.mu.Unlock()
+
+	if len(buf) > 0 {
+		_, _ = b.w.Write(buf)
+	}
+	if dropped > 0 && b.onDrop != nil {
+		b.onDrop(dropped)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingWriter blocks writes until unblock is closed if it's set, and
+// signals writing for each write.
+type blockingWriter struct {
+	writing chan struct{}
+	unblock chan struct{}
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	if w.writing != nil {
+		w.writing <- struct{}{}
+	}
+	if w.unblock != nil {
+		<-w.unblock
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// waitFor polls cond for up to a second.
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestLogBufferInterval(t *testing.T) {
+	w := &blockingWriter{}
+	b := newLogBuffer(w, 64, 10*time.Millisecond, nil)
+	// It's below half of the buffer, so it's only flushed on interval.
+	_, _ = b.Write([]byte("line\n"))
+	if !waitFor(func() bool { return w.String() == "line\n" }) {
+		t.Errorf("written %q after interval, want %q", w.String(), "line\n")
+	}
+}
+
+func TestLogBufferFull(t *testing.T) {
+	w := &blockingWriter{writing: make(chan struct{}, 2), unblock: make(chan struct{})}
+	var mu sync.Mutex
+	dropped := 0
+	b := newLogBuffer(w, 16, time.Hour, func(n int) {
+		mu.Lock()
+		dropped += n
+		mu.Unlock()
+	})
+
+	// Half of the buffer wakes up a flush, which blocks on w.
+	_, _ = b.Write([]byte("1234567\n"))
+	<-w.writing
+	// Writes don't block while flushing, and are dropped once the buffer
+	// is full.
+	for _, line := range []string{"abcdefg\n", "hijklmn\n", "dropped\n", "dropped\n"} {
+		if n, err := b.Write([]byte(line)); n != len(line) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", line, n, err)
+		}
+	}
+	close(w.unblock)
+
+	want := "1234567\nabcdefg\nhijklmn\n"
+	if !waitFor(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return w.String() == want && dropped == 2
+	}) {
+		t.Errorf("written %q with %d dropped, want %q with 2 dropped", w.String(), dropped, want)
+	}
+}
+
+func TestLogBufferExit(t *testing.T) {
+	w := &blockingWriter{}
+	l := &accessLog{w: w, format: "clf"}
+	l.buffer(64, time.Hour, nil)
+	_, _ = l.w.Write([]byte("last\n"))
+	if got := w.String(); got != "" {
+		t.Fatalf("written %q before exit, want nothing", got)
+	}
+	runExitHooks()
+	if got := w.String(); !strings.HasSuffix(got, "last\n") {
+		t.Errorf("written %q at exit, want %q", got, "last\n")
+	}
+}
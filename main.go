@@ -9,81 +9,496 @@ import (
 	"net/netip"
 	"os"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
 	"golang.zx2c4.com/wireguard/tun/netstack"
 
+	"github.com/zhsj/wghttp/internal/ipfix"
 	"github.com/zhsj/wghttp/internal/proxy"
+	"github.com/zhsj/wghttp/internal/resolver"
 )
 
 //go:embed README.md
 var readme string
 
-var (
-	logger *device.Logger
-	opts   options
-)
+const helpDescriptionPlaceholder = "@DESCRIPTION@"
+
+// isTerminal reports whether f is a terminal, or rather a character device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
 
 func main() {
-	parser := flags.NewParser(&opts, flags.Default)
-	parser.LongDescription = fmt.Sprintf("wghttp %s\n\n", version())
-	parser.LongDescription += strings.Trim(strings.TrimPrefix(readme, "# wghttp"), "\n")
+	var opts options
+	parser := flags.NewParser(&opts, flags.Default&^flags.PrintErrors)
+	description := fmt.Sprintf("wghttp %s\n\n", version())
+	description += strings.Trim(strings.TrimPrefix(readme, "# wghttp"), "\n")
+	parser.LongDescription = description
+	if !isTerminal(os.Stdout) {
+		// go-flags wraps the description to the terminal width, or 80
+		// columns, which breaks the code blocks of README. It's put back
+		// unwrapped for files and pagers.
+		parser.LongDescription = helpDescriptionPlaceholder
+	}
+	if err := loadConfig(parser); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	if _, err := parser.Parse(); err != nil {
-		code := 1
 		fe := &flags.Error{}
 		if errors.As(err, &fe) && fe.Type == flags.ErrHelp {
-			code = 0
+			fmt.Println(strings.Replace(fe.Message, helpDescriptionPlaceholder, description, 1))
+			os.Exit(0)
+		}
+		// Only --private-key is needed by --print-ula.
+		if !opts.PrintULA || !errors.As(err, &fe) || fe.Type != flags.ErrRequired {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if opts.PrintULA {
+		if err := printULA(opts.PrivateKey); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := opts.mergePeerEndpoint(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if opts.Stdio != "" && len(opts.Tunnels) > 0 {
+		fmt.Fprintln(os.Stderr, "--stdio can't be used with --tunnel")
+		os.Exit(1)
+	}
+	if len(opts.TestMatrix) > 0 && (opts.Stdio != "" || len(opts.Tunnels) > 0) {
+		fmt.Fprintln(os.Stderr, "--test-matrix can't be used with --stdio or --tunnel")
+		os.Exit(1)
+	}
+
+	tunnels := []*tunnel{{name: opts.InterfaceName, opts: opts}}
+	for _, file := range opts.Tunnels {
+		t, err := loadTunnel(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		tunnels = append(tunnels, t)
+	}
+
+	// DNS of each tunnel is checked before any tunnel starts, so that a
+	// misconfigured tunnel doesn't leave others half started.
+	for _, t := range tunnels {
+		t.logOut = os.Stdout
+		if t.opts.Stdio != "" || len(t.opts.TestMatrix) > 0 {
+			t.logOut = os.Stderr
+		}
+		t.verbose.Store(t.opts.Verbose)
+		t.logger = newLogger(t.logOut, &t.verbose, t.logPrefix())
+		if err := t.checkDNS(); err != nil {
+			t.logger.Errorf("Check DNS: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.Stdio != "" {
+		if err := tunnels[0].relayStdio(); err != nil {
+			tunnels[0].logger.Errorf("Relay stdio: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(opts.TestMatrix) > 0 {
+		if err := tunnels[0].testMatrix(os.Stdout); err != nil {
+			tunnels[0].logger.Errorf("Test matrix: %v", err)
+			os.Exit(1)
 		}
-		os.Exit(code)
+		return
+	}
+
+	go dumpOnQuit(tunnels)
+
+	// Privileges are dropped once every tunnel has bound its addresses.
+	var bound sync.WaitGroup
+	bound.Add(len(tunnels))
+	dropped := make(chan struct{})
+	done := make(chan struct{})
+	for _, t := range tunnels {
+		t := t
+		t.ready = func() {
+			bound.Done()
+			<-dropped
+		}
+		go func() {
+			t.run()
+			done <- struct{}{}
+		}()
+	}
+	bound.Wait()
+	if err := dropPrivileges(opts.User, opts.Group, tunnels[0].logger.Errorf); err != nil {
+		tunnels[0].logger.Errorf("Drop privileges: %v", err)
+		os.Exit(1)
+	}
+	close(dropped)
+	go toggleVerbose(tunnels)
+	<-done
+	exit(1)
+}
+
+// run sets up the tunnel and serves its proxy until it fails.
+func (t *tunnel) run() {
+	t.start = time.Now()
+	for _, path := range t.opts.GeoIP {
+		t.geoIPs = append(t.geoIPs, &geoIP{path: path})
 	}
-	if opts.Verbose {
-		logger = device.NewLogger(device.LogLevelVerbose, "")
-	} else {
-		logger = device.NewLogger(device.LogLevelError, "")
+	t.logger.Verbosef("Options: %+v", t.opts)
+
+	var admin *adminServer
+	t.setStage("starting")
+	if t.opts.AdminListen != "" {
+		admin = t.listenAdmin()
+	}
+
+	if err := t.checkKnownPeers(); err != nil {
+		t.logger.Errorf("Check known peers: %v", err)
+		os.Exit(1)
+	}
+	if t.opts.TUNError == "rebuild" && t.opts.ExitMode == "local" {
+		// The proxy listens in the network of device.
+		t.logger.Errorf("--tun-error=rebuild can't be used with --exit-mode=local")
+		os.Exit(1)
 	}
-	logger.Verbosef("Options: %+v", opts)
 
-	dev, tnet, err := setupNet()
+	dev, tnet, err := t.setupNet()
 	if err != nil {
-		logger.Errorf("Setup netstack: %v", err)
+		t.logger.Errorf("Setup netstack: %v", err)
 		os.Exit(1)
 	}
 
-	listener, err := proxyListener(tnet)
+	t.setStage("creating listener")
+	listener, err := t.proxyListener(tnet)
 	if err != nil {
-		logger.Errorf("Create net listener: %v", err)
+		t.logger.Errorf("Create net listener: %v", err)
 		os.Exit(1)
 	}
 
+	allowedSources := []netip.Prefix{}
+	for _, prefix := range t.opts.AllowSources {
+		allowedSources = append(allowedSources, netip.Prefix(prefix))
+	}
+	allowedHosts, err := t.allowedHosts()
+	if err != nil {
+		t.logger.Errorf("Load allowed hosts: %v", err)
+		os.Exit(1)
+	}
+	counters := &proxy.Counters{}
+	t.counters.Store(counters)
+	dnsStats := &resolver.Stats{}
+	handshakes := &handshakeMonitor{
+		minInterval: time.Duration(t.opts.HandshakeMinInterval) * time.Second,
+		warn:        t.logger.Errorf,
+	}
+	go monitorHandshakes(dev, handshakes)
 	proxier := proxy.Proxy{
-		Dial: proxyDialer(tnet), DNS: opts.DNS, Stats: stats(dev),
+		Dial:     t.proxyDialer(),
+		DNS:      t.opts.DNS,
+		Stats:    t.stats(counters, dnsStats, handshakes),
+		Counters: counters,
+		DNSStats: dnsStats,
+
+		DialTimeout:    time.Duration(t.opts.DialTimeout) * time.Second,
+		MaxDialTimeout: time.Duration(t.opts.MaxDialTimeout) * time.Second,
+
+		AdaptiveDialTimeoutMin: time.Duration(t.opts.AdaptiveDialTimeoutMin) * time.Second,
+		AdaptiveDialTimeoutMax: time.Duration(t.opts.AdaptiveDialTimeoutMax) * time.Second,
+
+		DNSSourcePort: t.opts.DNSSourcePort,
+		DNSDialUDP:    t.dnsUDPDialer(),
+
+		DNSFallback:        t.opts.DNSFallback,
+		DNSFallbackTimeout: time.Duration(t.opts.DNSFallbackTimeout) * time.Second,
+
+		DNSRetransmits:        t.opts.DNSRetransmits,
+		DNSRetransmitInterval: time.Duration(t.opts.DNSRetransmitInterval) * time.Second,
+		DNSTLSMinVersion:      uint16(t.opts.TLSMinVersion),
+		DNSTLSCipherSuites:    t.opts.tlsCipherSuites(),
+
+		AllowedSources: allowedSources,
+		AllowedHosts:   allowedHosts,
+		ClientNetwork:  map[string]string{"ipv4": "tcp4", "ipv6": "tcp6"}[t.opts.ListenFamily],
+
+		AcceptRate:  t.opts.AcceptRate,
+		AcceptBurst: t.opts.AcceptBurst,
+
+		TotalRate:  t.opts.TotalRate,
+		TotalBurst: t.opts.TotalBurst,
+
+		RelayBufferMemory: t.opts.RelayBufferMemory,
+
+		TCPDelay:    t.opts.TCPDelay,
+		TCPQuickAck: t.opts.TCPQuickAck,
+
+		IdleTimeout: time.Duration(t.opts.IdleTimeout) * time.Second,
+
+		UpstreamIdleConns:   t.opts.UpstreamPoolSize,
+		UpstreamIdleTimeout: time.Duration(t.opts.UpstreamIdleTimeout) * time.Second,
+
+		WebUI:       t.opts.WebUI,
+		WGQuickConf: t.wgQuickConf(),
+		WGQuickKeys: t.opts.WGQuickKeys,
+
+		HealthPath: t.opts.HealthPath,
+		HealthBody: t.opts.HealthBody,
+
+		ForwardTo: t.opts.Forward,
+	}
+
+	if t.opts.ExitMode == "remote" {
+		proxier.Network = t.tunnelNetwork()
+	}
+	if err := t.setupDNSReplay(&proxier); err != nil {
+		t.logger.Errorf("Setup DNS record and replay: %v", err)
+		os.Exit(1)
+	}
+	if (t.opts.AdaptiveDialTimeoutMin > 0) != (t.opts.AdaptiveDialTimeoutMax > 0) ||
+		t.opts.AdaptiveDialTimeoutMin > t.opts.AdaptiveDialTimeoutMax {
+		t.logger.Errorf("--adaptive-dial-timeout-min and --adaptive-dial-timeout-max must be set together, and min can't be greater than max")
+		os.Exit(1)
+	}
+	if t.opts.UpstreamPoolSize == 0 {
+		// Zero is the default of http.Transport.
+		proxier.UpstreamIdleConns = -1
+	}
+	if t.opts.ClientLinger >= 0 {
+		proxier.ClientLinger = &t.opts.ClientLinger
+	}
+	if t.opts.UpstreamLinger >= 0 {
+		proxier.UpstreamLinger = &t.opts.UpstreamLinger
+	}
+
+	if t.opts.PAC {
+		proxier.PACProxy = t.opts.PACProxy
+		if proxier.PACProxy == "" {
+			proxier.PACProxy = listener.Addr().String()
+		}
+		proxier.PACDomains = t.opts.PACDomains
 	}
+
+	if t.opts.InfluxDBURL != "" {
+		interval := time.Duration(t.opts.InfluxDBInterval) * time.Second
+		if interval <= 0 {
+			t.logger.Errorf("InfluxDB interval must be positive")
+			os.Exit(1)
+		}
+		go t.pushInflux(t.opts.InfluxDBURL, t.opts.InfluxDBToken, interval, proxier.Stats)
+	}
+
+	if t.opts.OTLPEndpoint != "" {
+		interval := time.Duration(t.opts.OTLPInterval) * time.Second
+		if interval <= 0 {
+			t.logger.Errorf("OTLP interval must be positive")
+			os.Exit(1)
+		}
+		headers, err := otlpHeaders(t.opts.OTLPHeaders)
+		if err != nil {
+			t.logger.Errorf("Parse OTLP headers: %v", err)
+			os.Exit(1)
+		}
+		go t.pushOTLP(t.opts.OTLPEndpoint, headers, interval, proxier.Stats)
+	}
+
+	var onClose []func(proxy.Record)
+	if t.opts.AccessLog != "" {
+		accessLog, err := newAccessLog(t.opts.AccessLog, t.opts.AccessLogFormat)
+		if err != nil {
+			t.logger.Errorf("Open access log: %v", err)
+			os.Exit(1)
+		}
+		if t.opts.AccessLogBuffer > 0 {
+			interval := time.Duration(t.opts.AccessLogFlushInterval) * time.Second
+			if interval <= 0 {
+				t.logger.Errorf("Access log flush interval must be positive")
+				os.Exit(1)
+			}
+			accessLog.buffer(t.opts.AccessLogBuffer, interval, func(dropped int) {
+				t.logger.Errorf("Access log buffer is full, %d entries are dropped", dropped)
+			})
+		}
+		if t.opts.AccessLogFormat == "jsonl" {
+			accessLog.tunnel, accessLog.peer = t.name, t.opts.PeerKey.base64()
+			onClose = append(onClose, accessLog.logConn)
+		} else {
+			proxier.OnRequest = accessLog.log
+		}
+	}
+	if t.opts.IPFIXCollector != "" {
+		exporter, err := ipfix.NewExporter(t.opts.IPFIXCollector)
+		if err != nil {
+			t.logger.Errorf("Create IPFIX exporter: %v", err)
+			os.Exit(1)
+		}
+		onClose = append(onClose, func(r proxy.Record) { t.exportFlows(exporter, r) })
+	}
+	if threshold := time.Duration(t.opts.SlowConnThreshold) * time.Second; threshold > 0 {
+		onClose = append(onClose, func(r proxy.Record) { t.logSlowConn(r, threshold) })
+	}
+	if t.opts.ExitMode == "remote" {
+		detector := &mtuDetector{mtu: t.opts.MTU, warn: t.logger.Errorf}
+		onClose = append(onClose, detector.observe)
+	}
+	if len(onClose) > 0 {
+		proxier.OnClose = func(r proxy.Record) {
+			for _, f := range onClose {
+				f(r)
+			}
+		}
+	}
+
+	var adminAddr net.Addr
+	if admin != nil {
+		adminAddr = admin.addr
+		// Only listeners in the network of proxy dialer are checked for
+		// loops. The admin server is in local network, which is dialed in
+		// local exit mode, while the proxy listener is always in the other
+		// network.
+		if t.opts.ExitMode == "local" {
+			proxier.SelfAddrs = append(proxier.SelfAddrs, adminAddr)
+		}
+	}
+
+	t.setStage("waiting for other tunnels")
+	t.ready()
+	t.setStage(stageReady)
+	if admin != nil {
+		admin.serve(proxier.AdminHandler())
+	}
+	if t.opts.ReadyFile != "" {
+		status := func() (peerStatus, error) { return readPeerStatus(dev) }
+		go t.watchReadyFile(t.opts.ReadyFile, time.Duration(t.opts.ReadyStaleAfter)*time.Second, readyFilePollInterval, status, dev.Wait())
+	}
+	t.printBanner(dev, listener.Addr(), adminAddr)
 	proxier.Serve(listener)
+}
 
-	os.Exit(1)
+// setupDNSReplay sets the file to record DNS lookups to, or the recorded
+// lookups to replay.
+func (t *tunnel) setupDNSReplay(p *proxy.Proxy) error {
+	if t.opts.DNSRecord != "" && t.opts.DNSReplay != "" {
+		return errors.New("--dns-record and --dns-replay can't be used together")
+	}
+	if t.opts.DNSRecord != "" {
+		f, err := os.OpenFile(t.opts.DNSRecord, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		p.DNSRecord = f
+	}
+	if t.opts.DNSReplay != "" {
+		f, err := os.Open(t.opts.DNSReplay)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		p.DNSReplay, err = resolver.ReadLookups(f)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", t.opts.DNSReplay, err)
+		}
+	}
+	return nil
 }
 
-func proxyDialer(tnet *netstack.Net) (dialer func(ctx context.Context, network, address string) (net.Conn, error)) {
-	switch opts.ExitMode {
+func (t *tunnel) proxyDialer() (dialer func(ctx context.Context, network, address string) (net.Conn, error)) {
+	switch t.opts.ExitMode {
 	case "local":
 		d := net.Dialer{}
 		dialer = d.DialContext
 	case "remote":
-		dialer = tnet.DialContext
+		dialer = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return t.tnet.Load().DialContext(ctx, network, address)
+		}
 	}
 	return
 }
 
-func proxyListener(tnet *netstack.Net) (net.Listener, error) {
+// dnsUDPDialer returns the dialer of UDP DNS queries with fixed source
+// port, like proxyDialer.
+func (t *tunnel) dnsUDPDialer() resolver.DialUDP {
+	switch t.opts.ExitMode {
+	case "local":
+		return func(ctx context.Context, laddr, raddr netip.AddrPort) (net.Conn, error) {
+			d := net.Dialer{LocalAddr: net.UDPAddrFromAddrPort(laddr)}
+			return d.DialContext(ctx, "udp", raddr.String())
+		}
+	default:
+		return func(_ context.Context, laddr, raddr netip.AddrPort) (net.Conn, error) {
+			return t.tnet.Load().DialUDPAddrPort(laddr, raddr)
+		}
+	}
+}
+
+// checkDNS checks that the DNS server can be reached in WireGuard network.
+// In local exit mode, it's reached in local network instead.
+func (t *tunnel) checkDNS() error {
+	ip, ok := resolver.ServerIP(t.opts.DNS)
+	if t.opts.DNSSourcePort != 0 {
+		scheme, _, hasScheme := strings.Cut(t.opts.DNS, "://")
+		if !ok || hasScheme && scheme != "udp" {
+			return errors.New("--dns-source-port requires --dns of UDP DNS with IP address")
+		}
+	}
+	if !ok || t.opts.ExitMode != "remote" {
+		return nil
+	}
+	if ip.Zone() != "" || ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("link-local address %s can't be used in WireGuard network", ip)
+	}
+	for _, clientIP := range t.opts.ClientIPs {
+		if netip.Addr(clientIP).Is4() == ip.Is4() {
+			return nil
+		}
+	}
+	if ip.Is4() {
+		return fmt.Errorf("%s is IPv4, but there's no IPv4 client IP", ip)
+	}
+	return fmt.Errorf("%s is IPv6, but there's no IPv6 client IP", ip)
+}
+
+// tunnelNetwork returns tcp4 or tcp6 if client IPs are all in the same
+// address family, so that destinations in the other family are not tried.
+func (t *tunnel) tunnelNetwork() string {
+	var has4, has6 bool
+	for _, ip := range t.opts.ClientIPs {
+		if netip.Addr(ip).Is4() {
+			has4 = true
+		} else {
+			has6 = true
+		}
+	}
+	switch {
+	case has4 && !has6:
+		return "tcp4"
+	case has6 && !has4:
+		return "tcp6"
+	}
+	return ""
+}
+
+func (t *tunnel) proxyListener(tnet *netstack.Net) (net.Listener, error) {
 	var tcpListener net.Listener
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", opts.Listen)
+	tcpAddr, err := net.ResolveTCPAddr("tcp", t.opts.Listen)
 	if err != nil {
 		return nil, fmt.Errorf("resolve listen addr: %w", err)
 	}
 
-	switch opts.ExitMode {
+	switch t.opts.ExitMode {
 	case "local":
 		tcpListener, err = tnet.ListenTCP(tcpAddr)
 		if err != nil {
@@ -95,28 +510,97 @@ func proxyListener(tnet *netstack.Net) (net.Listener, error) {
 			return nil, fmt.Errorf("create listener on local net: %w", err)
 		}
 	}
-	logger.Verbosef("Listening on %s", tcpListener.Addr())
+	t.logger.Verbosef("Listening on %s", tcpListener.Addr())
 	return tcpListener, nil
 }
 
-func setupNet() (*device.Device, *netstack.Net, error) {
+func (t *tunnel) setupNet() (*device.Device, *netstack.Net, error) {
 	clientIPs := []netip.Addr{}
-	for _, ip := range opts.ClientIPs {
+	for _, ip := range t.opts.ClientIPs {
 		clientIPs = append(clientIPs, netip.Addr(ip))
 	}
-	tun, tnet, err := netstack.CreateNetTUN(clientIPs, nil, opts.MTU)
+	t.setStage("creating netstack")
+	nsTUN, tnet, err := netstack.CreateNetTUN(clientIPs, nil, t.opts.MTU)
 	if err != nil {
 		return nil, nil, fmt.Errorf("create netstack tun: %w", err)
 	}
-	dev := device.NewDevice(tun, newConnBind(opts.ClientID), logger)
+	errTUN := &errorTUN{t: t, dev: nsTUN}
+	if t.opts.TUNError == "rebuild" {
+		// Only netstack is replaced, so the device keeps its peer and
+		// socket, and the capture file is kept.
+		errTUN.rebuild = func() (tun.Device, error) {
+			nsTUN, tnet, err := netstack.CreateNetTUN(clientIPs, nil, t.opts.MTU)
+			if err != nil {
+				return nil, err
+			}
+			t.tnet.Store(tnet)
+			return nsTUN, nil
+		}
+	}
+	var tunDev tun.Device = errTUN
+	if t.opts.Capture != "" {
+		tunDev, err = newCaptureTUN(errTUN, t.opts.Capture, t.opts.MTU, t.opts.CaptureMaxSize, func() {
+			t.logger.Errorf("Capture file %s reached %d bytes, stop capturing", t.opts.Capture, t.opts.CaptureMaxSize)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("create capture file: %w", err)
+		}
+		t.logger.Verbosef("Capturing packets of WireGuard network to %s", t.opts.Capture)
+	}
+	bind, err := t.newConnBind(t.opts.ClientID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create bind: %w", err)
+	}
+	dev := device.NewDevice(tunDev, bind, t.logger)
 
-	if err := ipcSet(dev); err != nil {
+	t.setStage("configuring device")
+	if err := t.ipcSet(dev); err != nil {
 		return nil, nil, fmt.Errorf("config device: %w", err)
 	}
 
-	if err := dev.Up(); err != nil {
+	t.setStage("bringing up device")
+	if err := t.upDevice(dev); err != nil {
 		return nil, nil, fmt.Errorf("bring up device: %w", err)
 	}
 
+	t.dev.Store(dev)
+	t.tnet.Store(tnet)
 	return dev, tnet, nil
 }
+
+// upDevice brings up dev, and retries with backoff if the error may be
+// transient, like the address not being available yet.
+func (t *tunnel) upDevice(dev *device.Device) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := dev.Up()
+		if err == nil {
+			return nil
+		}
+		if attempt >= t.opts.UpRetries || !isTransient(err) {
+			return err
+		}
+		t.logger.Errorf("Bring up device (attempt %d): %v, retrying in %v", attempt+1, err, backoff)
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func isTransient(err error) bool {
+	for _, errno := range []syscall.Errno{
+		syscall.EADDRINUSE,
+		syscall.EADDRNOTAVAIL,
+		syscall.ENETDOWN,
+		syscall.ENETUNREACH,
+		syscall.ENOBUFS,
+		syscall.EAGAIN,
+		syscall.EINTR,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
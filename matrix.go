@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// matrixResult is the result of connecting to a target of --test-matrix.
+type matrixResult struct {
+	target  string
+	latency time.Duration
+	err     error
+}
+
+// testMatrix connects to each target of --test-matrix through the tunnel,
+// and writes the results as a table to w. It returns an error if any
+// target fails.
+func (t *tunnel) testMatrix(w io.Writer) error {
+	if err := t.checkKnownPeers(); err != nil {
+		return err
+	}
+	if _, _, err := t.setupNet(); err != nil {
+		return err
+	}
+	proxier, err := t.standaloneProxy()
+	if err != nil {
+		return err
+	}
+
+	results := make([]matrixResult, len(t.opts.TestMatrix))
+	var wg sync.WaitGroup
+	for i, target := range t.opts.TestMatrix {
+		i, target := i, target
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			c, err := proxier.DialUpstream(context.Background(), "tcp", target)
+			if err == nil {
+				c.Close()
+			}
+			results[i] = matrixResult{target, time.Since(start), err}
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tRESULT\tLATENCY\tERROR")
+	for _, r := range results {
+		latency := r.latency.Round(time.Millisecond)
+		if r.err != nil {
+			failed++
+			fmt.Fprintf(tw, "%s\tfail\t%v\t%v\n", r.target, latency, r.err)
+		} else {
+			fmt.Fprintf(tw, "%s\tpass\t%v\t\n", r.target, latency)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d targets failed", failed, len(results))
+	}
+	return nil
+}
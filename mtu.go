@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zhsj/wghttp/internal/proxy"
+)
+
+const (
+	// stallDuration is how long a connection without a full-sized
+	// response lasts to be counted as stalled.
+	stallDuration = 10 * time.Second
+	// stallCount stalls in stallWindow trigger a warning, which is
+	// repeated at most every stallWarnInterval.
+	stallCount        = 3
+	stallWindow       = 5 * time.Minute
+	stallWarnInterval = 30 * time.Minute
+)
+
+// mtuDetector warns about possible MTU blackhole. The symptom is that
+// connections are established and small packets get through, but they
+// stall once a full-sized packet is sent, like a TLS certificate.
+type mtuDetector struct {
+	mtu  int
+	warn func(format string, args ...any)
+
+	mu     sync.Mutex
+	stalls []time.Time
+	warned time.Time
+}
+
+func (d *mtuDetector) observe(r proxy.Record) {
+	if r.Destination == nil || r.SentBytes == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if r.ReceivedBytes > int64(d.mtu) {
+		// Large packets get through.
+		d.stalls = d.stalls[:0]
+		return
+	}
+	if r.End.Sub(r.Start) < stallDuration {
+		return
+	}
+
+	now := time.Now()
+	recent := d.stalls[:0]
+	for _, t := range d.stalls {
+		if now.Sub(t) < stallWindow {
+			recent = append(recent, t)
+		}
+	}
+	d.stalls = append(recent, now)
+
+	if len(d.stalls) >= stallCount && now.Sub(d.warned) > stallWarnInterval {
+		d.warned = now
+		d.warn(
+			"%d connections stalled after receiving less than %d bytes, last to %s (%v). "+
+				"It may be MTU blackhole, try lowering --mtu (current %d)",
+			len(d.stalls), d.mtu, r.Host, r.Destination, d.mtu,
+		)
+	}
+}
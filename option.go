@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
 	"net/netip"
+	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/jessevdk/go-flags"
 )
 
 type ipT netip.Addr
@@ -21,13 +29,66 @@ func (o ipT) String() string {
 	return netip.Addr(o).String()
 }
 
+type prefixT netip.Prefix
+
+func (o *prefixT) UnmarshalFlag(value string) error {
+	if ip, err := netip.ParseAddr(value); err == nil {
+		*o = prefixT(netip.PrefixFrom(ip, ip.BitLen()))
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(value)
+	*o = prefixT(prefix.Masked())
+	return err
+}
+
+func (o prefixT) String() string {
+	return netip.Prefix(o).String()
+}
+
+// allowedIPsT is a list of prefixes. Keywords default, default4 and
+// default6 are expanded to the default routes. Prefixes set more than once
+// are only kept once.
+type allowedIPsT []netip.Prefix
+
+func (o *allowedIPsT) UnmarshalFlag(value string) error {
+	default4 := netip.MustParsePrefix("0.0.0.0/0")
+	default6 := netip.MustParsePrefix("::/0")
+	switch value {
+	case "default":
+		o.add(default4, default6)
+	case "default4":
+		o.add(default4)
+	case "default6":
+		o.add(default6)
+	default:
+		var prefix prefixT
+		if err := prefix.UnmarshalFlag(value); err != nil {
+			return err
+		}
+		o.add(netip.Prefix(prefix))
+	}
+	return nil
+}
+
+func (o *allowedIPsT) add(prefixes ...netip.Prefix) {
+	for _, prefix := range prefixes {
+		dup := false
+		for _, p := range *o {
+			dup = dup || p == prefix
+		}
+		if !dup {
+			*o = append(*o, prefix)
+		}
+	}
+}
+
 type hostPortT struct {
 	host string
 	port uint16
 }
 
 func (o *hostPortT) UnmarshalFlag(value string) error {
-	host, port, err := net.SplitHostPort(value)
+	host, port, err := net.SplitHostPort(expandEnv(value))
 	if err != nil {
 		return err
 	}
@@ -36,12 +97,96 @@ func (o *hostPortT) UnmarshalFlag(value string) error {
 	return err
 }
 
+// expandEnv replaces ${VAR} and ${VAR:-default} in value with environment
+// variables.
+func expandEnv(value string) string {
+	return os.Expand(value, func(key string) string {
+		key, def, _ := strings.Cut(key, ":-")
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+		return def
+	})
+}
+
+func (o hostPortT) String() string {
+	return net.JoinHostPort(o.host, strconv.Itoa(int(o.port)))
+}
+
 type keyT string
 
 func (o *keyT) UnmarshalFlag(value string) error {
 	key, err := base64.StdEncoding.DecodeString(value)
+	if err == nil && len(key) != 32 {
+		err = fmt.Errorf("key is %d bytes, but WireGuard keys are 32 bytes", len(key))
+	}
+	if err != nil {
+		return keyFormatError(value, len(key), err)
+	}
 	*o = keyT(hex.EncodeToString(key))
-	return err
+	return nil
+}
+
+// keyFormatError explains why value isn't a WireGuard key, if it looks like
+// a key of other format pasted by mistake. Otherwise err is returned.
+func keyFormatError(value string, size int, err error) error {
+	var hint string
+	switch {
+	case strings.HasPrefix(value, "-----BEGIN"):
+		hint = "it looks like a PEM key of OpenSSL or OpenSSH"
+	case strings.HasPrefix(value, "ssh-") || strings.HasPrefix(value, "AAAAC3NzaC1lZDI1NTE5"):
+		hint = "it looks like an SSH public key"
+	case size == 64:
+		hint = "it's 64 bytes, like an Ed25519 private key with the public key appended"
+	case len(value) == 64 && strings.Trim(value, "0123456789abcdefABCDEF") == "":
+		hint = "it looks hex encoded"
+	case strings.ContainsAny(value, "-_"):
+		hint = "it looks like URL-safe base64"
+	case isUnpadded32(value):
+		hint = "its base64 padding is wrong, it should be 44 characters ending with ="
+	default:
+		return err
+	}
+	return fmt.Errorf("%s, but WireGuard keys are 32 bytes Curve25519 keys in standard base64, like the output of wg genkey", hint)
+}
+
+// isUnpadded32 reports whether value is 32 bytes in base64 without the
+// right padding, e.g. its trailing "=" is lost when it's copied.
+func isUnpadded32(value string) bool {
+	key, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "="))
+	return err == nil && len(key) == 32
+}
+
+// base64 returns the key in the format of WireGuard configuration file.
+func (o keyT) base64() string {
+	key, _ := hex.DecodeString(string(o))
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+type tlsVersionT uint16
+
+func (o *tlsVersionT) UnmarshalFlag(value string) error {
+	switch value {
+	case "1.2":
+		*o = tls.VersionTLS12
+	case "1.3":
+		*o = tls.VersionTLS13
+	default:
+		return fmt.Errorf("unsupported TLS version %q", value)
+	}
+	return nil
+}
+
+type tlsCipherT uint16
+
+func (o *tlsCipherT) UnmarshalFlag(value string) error {
+	for _, c := range tls.CipherSuites() {
+		if c.Name == value {
+			*o = tlsCipherT(c.ID)
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported TLS cipher suite %q", value)
 }
 
 type timeT int64
@@ -64,17 +209,193 @@ type options struct {
 	DNS        string `long:"dns" env:"DNS" description:"[Interface].DNS\tfor WireGuard network (format: protocol://ip:port)\nProtocol includes udp(default), tcp, tls(DNS over TLS) and https(DNS over HTTPS)"`
 	MTU        int    `long:"mtu" env:"MTU" default:"1280" description:"[Interface].MTU\tfor WireGuard network"`
 
-	PeerEndpoint      hostPortT `long:"peer-endpoint" env:"PEER_ENDPOINT" required:"true" description:"[Peer].Endpoint\tfor WireGuard server (format: host:port)"`
-	PeerKey           keyT      `long:"peer-key" env:"PEER_KEY" required:"true" description:"[Peer].PublicKey\tfor WireGuard server (format: base64)"`
-	PresharedKey      keyT      `long:"preshared-key" env:"PRESHARED_KEY" description:"[Peer].PresharedKey\tfor WireGuard network (optional, format: base64)"`
-	KeepaliveInterval timeT     `long:"keepalive-interval" env:"KEEPALIVE_INTERVAL" description:"[Peer].PersistentKeepalive\tfor WireGuard network (optional)"`
+	BindAddress ipT `long:"bind-address" env:"BIND_ADDRESS" description:"Local address of the host to send WireGuard packets from (optional)\nThe address family of peer endpoint must match it"`
+
+	DNSRetransmits        int   `long:"dns-retransmits" env:"DNS_RETRANSMITS" default:"2" description:"Times to resend UDP DNS query for WireGuard network if there's no response in interval"`
+	DNSRetransmitInterval timeT `long:"dns-retransmit-interval" env:"DNS_RETRANSMIT_INTERVAL" default:"1s" description:"Interval to resend UDP DNS query for WireGuard network"`
+
+	DNSSourcePort uint16 `long:"dns-source-port" env:"DNS_SOURCE_PORT" description:"Fixed source port of UDP DNS queries for WireGuard network, e.g. for firewall rules (default: random per query)\nQueries are sent one at a time with it"`
+
+	DNSFallback        []string `long:"dns-fallback" env:"DNS_FALLBACK" env-delim:"," description:"DNS to try in order if --dns fails other than not found, e.g. when it's down (optional, can be set multiple times)\nFormat is the same as --dns, or system for the system resolver in local network"`
+	DNSFallbackTimeout timeT    `long:"dns-fallback-timeout" env:"DNS_FALLBACK_TIMEOUT" default:"3s" description:"Timeout for --dns before trying --dns-fallback"`
+
+	DNSRecord string `long:"dns-record" env:"DNS_RECORD" description:"File to record DNS lookups of proxy destinations (optional, format: JSON lines)"`
+	DNSReplay string `long:"dns-replay" env:"DNS_REPLAY" description:"File of recorded DNS lookups to answer proxy destinations from, without querying DNS (optional)"`
+
+	PeerEndpoint      hostPortT   `long:"peer-endpoint" env:"PEER_ENDPOINT" description:"[Peer].Endpoint\tfor WireGuard server (format: host:port)\n${VAR} and ${VAR:-default} are replaced with environment variables"`
+	PeerHost          string      `long:"peer-host" env:"PEER_ENDPOINT_HOST" description:"Host of [Peer].Endpoint, instead of --peer-endpoint (format: host, IPv6 address without brackets)"`
+	PeerPort          uint16      `long:"peer-port" env:"PEER_ENDPOINT_PORT" description:"Port of [Peer].Endpoint, instead of --peer-endpoint"`
+	PeerKey           keyT        `long:"peer-key" env:"PEER_KEY" required:"true" description:"[Peer].PublicKey\tfor WireGuard server (format: base64)"`
+	PresharedKey      keyT        `long:"preshared-key" env:"PRESHARED_KEY" description:"[Peer].PresharedKey\tfor WireGuard network (optional, format: base64)"`
+	KnownPeers        string      `long:"known-peers" env:"KNOWN_PEERS" description:"File of pinned peer keys to check --peer-key against (optional, format: host key per line)"`
+	KeepaliveInterval timeT       `long:"keepalive-interval" env:"KEEPALIVE_INTERVAL" description:"[Peer].PersistentKeepalive\tfor WireGuard network (optional)"`
+	AllowedIPs        allowedIPsT `long:"allowed-ip" env:"ALLOWED_IP" env-delim:"," default:"default" description:"[Peer].AllowedIPs\tfor WireGuard server (format: ip or CIDR, can be set multiple times)\nKeywords default, default4 and default6 are 0.0.0.0/0 and ::/0, 0.0.0.0/0, and ::/0"`
+
+	HandshakeMinInterval timeT `long:"handshake-min-interval" env:"HANDSHAKE_MIN_INTERVAL" description:"Warn if handshakes with peer happen more often than this, which indicates a rekeying storm (optional)"`
+
+	TLSMinVersion tlsVersionT  `long:"tls-min-version" env:"TLS_MIN_VERSION" choice:"1.2" choice:"1.3" default:"1.2" description:"Minimum TLS version for DNS over TLS and DNS over HTTPS"`
+	TLSCiphers    []tlsCipherT `long:"tls-cipher" env:"TLS_CIPHER" env-delim:"," description:"Allowed TLS 1.2 cipher suite for DNS over TLS and DNS over HTTPS (can be set multiple times, default: Go's secure cipher suites)"`
+
+	TUNError string `long:"tun-error" env:"TUN_ERROR" choice:"continue" choice:"rebuild" default:"continue" description:"Behaviour on errors of reading from or writing to TUN device of netstack\nSet to rebuild to create the device again, instead of logging and continuing"`
+
+	UpRetries int `long:"up-retries" env:"UP_RETRIES" default:"5" description:"Times to retry bringing up WireGuard device on transient errors"`
 
 	ResolveDNS      string `long:"resolve-dns" env:"RESOLVE_DNS" description:"DNS for resolving WireGuard server address (optional, format: protocol://ip:port)\nProtocol includes udp(default), tcp, tls(DNS over TLS) and https(DNS over HTTPS)"`
 	ResolveInterval timeT  `long:"resolve-interval" env:"RESOLVE_INTERVAL" default:"1m" description:"Interval for resolving WireGuard server address (set 0 to disable)"`
+	PeerFailover    bool   `long:"peer-failover" env:"PEER_FAILOVER" description:"Switch to other addresses of peer endpoint host if the current one doesn't respond"`
+
+	GeoIP []string `long:"geoip" env:"GEOIP" env-delim:"," description:"CSV file of IP ranges to describe peer endpoint address in stats, like IP to Country or ASN databases (optional, can be set multiple times, format: start,end,description)"`
 
 	Listen   string `long:"listen" env:"LISTEN" default:"localhost:8080" description:"HTTP & SOCKS5 server address"`
 	ExitMode string `long:"exit-mode" env:"EXIT_MODE" choice:"remote" choice:"local" default:"remote" description:"Exit mode"`
-	Verbose  bool   `short:"v" long:"verbose" description:"Show verbose debug information"`
+	Forward  string `long:"forward" env:"FORWARD" description:"Forward every connection on listen address to this destination, instead of serving HTTP & SOCKS5 (optional, format: host:port)"`
+
+	AllowSources []prefixT `long:"allow-source" env:"ALLOW_SOURCE" env-delim:"," description:"Allowed source address of proxy client (optional, format: ip or CIDR, can be set multiple times)\nIn local exit mode, it's the address in WireGuard network"`
+	ListenFamily string    `long:"listen-family" env:"LISTEN_FAMILY" choice:"both" choice:"ipv4" choice:"ipv6" default:"both" description:"Address family of proxy clients to accept, even if listen address accepts both"`
+
+	AllowHosts    []string `long:"allow-host" env:"ALLOW_HOST" env-delim:"," description:"Allowed destination host of proxy (optional, can be set multiple times)\nFormat is domain, *.domain for its subdomains, ip or CIDR"`
+	AllowHostFile string   `long:"allow-host-file" env:"ALLOW_HOST_FILE" description:"File of allowed destination hosts, one per line, in addition to --allow-host (optional)"`
+
+	AcceptRate  float64 `long:"accept-rate" env:"ACCEPT_RATE" description:"Limit of new connections per second (optional)"`
+	AcceptBurst int     `long:"accept-burst" env:"ACCEPT_BURST" description:"Burst of new connections over accept rate (default: same as accept rate)"`
+
+	TotalRate  float64 `long:"total-rate" env:"TOTAL_RATE" description:"Limit of total throughput of proxy clients in bytes per second (optional)"`
+	TotalBurst int     `long:"total-burst" env:"TOTAL_BURST" description:"Burst of throughput in bytes over total rate (default: same as total rate)"`
+
+	RelayBufferMemory int `long:"relay-buffer-memory" env:"RELAY_BUFFER_MEMORY" description:"Limit of total size in bytes of buffers relaying data of proxy connections, which wait for a buffer once it's reached (optional)\nHTTP requests without CONNECT are not limited"`
+
+	ClientLinger   int `long:"client-linger" env:"CLIENT_LINGER" default:"-1" description:"SO_LINGER in seconds for proxy client connections in local network\nSet 0 to reset on close, or -1 to use OS default"`
+	UpstreamLinger int `long:"upstream-linger" env:"UPSTREAM_LINGER" default:"-1" description:"SO_LINGER in seconds for proxy destination connections in local network\nSet 0 to reset on close, or -1 to use OS default"`
+
+	TCPDelay    bool `long:"tcp-delay" env:"TCP_DELAY" description:"Enable Nagle's algorithm for proxy connections in local network, instead of TCP_NODELAY"`
+	TCPQuickAck bool `long:"tcp-quickack" env:"TCP_QUICKACK" description:"Set TCP_QUICKACK for proxy connections in local network to send ACKs immediately (Linux only)"`
+
+	DialTimeout    timeT `long:"dial-timeout" env:"DIAL_TIMEOUT" default:"5s" description:"Timeout for connecting to proxy destination (set 0 to disable)"`
+	MaxDialTimeout timeT `long:"max-dial-timeout" env:"MAX_DIAL_TIMEOUT" default:"1m" description:"Maximum timeout for connecting to proxy destination requested by HTTP proxy clients with X-Wghttp-Dial-Timeout header (set 0 to ignore the header)"`
+
+	AdaptiveDialTimeoutMin timeT `long:"adaptive-dial-timeout-min" env:"ADAPTIVE_DIAL_TIMEOUT_MIN" description:"Lower bound of dial timeout adapting to the latency of recent dials, starting from --dial-timeout (optional, set with --adaptive-dial-timeout-max)"`
+	AdaptiveDialTimeoutMax timeT `long:"adaptive-dial-timeout-max" env:"ADAPTIVE_DIAL_TIMEOUT_MAX" description:"Upper bound of adaptive dial timeout (optional, set with --adaptive-dial-timeout-min)"`
+
+	IdleTimeout timeT `long:"idle-timeout" env:"IDLE_TIMEOUT" description:"Close proxy client connections without traffic in either direction for this duration, e.g. half-open ones of clients gone without closing them (optional)"`
+
+	UpstreamPoolSize    int   `long:"upstream-pool-size" env:"UPSTREAM_POOL_SIZE" default:"2" description:"Idle destination connections kept per host for reuse by HTTP proxy requests (set 0 to disable)"`
+	UpstreamIdleTimeout timeT `long:"upstream-idle-timeout" env:"UPSTREAM_IDLE_TIMEOUT" default:"90s" description:"Timeout for idle destination connections kept by HTTP proxy (set 0 to disable)"`
+
+	AdminListen      string `long:"admin-listen" env:"ADMIN_LISTEN" description:"Admin server address for stats (optional)"`
+	AdminBindFailure string `long:"admin-bind-failure" env:"ADMIN_BIND_FAILURE" choice:"fatal" choice:"warn" default:"fatal" description:"Behaviour when admin server address can't be bound\nSet to warn to keep proxy running with admin server disabled"`
+	WebUI            bool   `long:"web-ui" env:"WEB_UI" description:"Serve web page for stats on admin server"`
+	WGQuickKeys      bool   `long:"wg-quick-keys" env:"WG_QUICK_KEYS" description:"Allow /wg-quick.conf?keys=true on admin server to export private and preshared keys (sensitive)"`
+
+	HealthPath string `long:"health-path" env:"HEALTH_PATH" default:"/health" description:"Path of health check on proxy port and admin server (set empty to disable)"`
+	HealthBody string `long:"health-body" env:"HEALTH_BODY" default:"OK" description:"Response body of health check"`
+
+	ReadyFile       string `long:"ready-file" env:"READY_FILE" description:"File to write once there's a handshake with peer, and remove when the peer stops responding or at exit, for supervisors watching files (optional)"`
+	ReadyStaleAfter timeT  `long:"ready-stale-after" env:"READY_STALE_AFTER" default:"3m" description:"Time without handshake after which --ready-file is removed if the peer doesn't respond"`
+
+	PAC        bool     `long:"pac" env:"PAC" description:"Serve PAC file at /proxy.pac on proxy and admin server"`
+	PACProxy   string   `long:"pac-proxy" env:"PAC_PROXY" description:"Proxy address in PAC file (default: listen address)"`
+	PACDomains []string `long:"pac-domain" env:"PAC_DOMAIN" env-delim:"," description:"Domain to use proxy in PAC file, including its subdomains (can be set multiple times, default: all domains)"`
+
+	AccessLog       string `long:"access-log" env:"ACCESS_LOG" description:"File to write access log of HTTP proxy requests (optional, set - for stdout)"`
+	AccessLogFormat string `long:"access-log-format" env:"ACCESS_LOG_FORMAT" choice:"clf" choice:"combined" choice:"jsonl" default:"clf" description:"Access log format\nclf is Common Log Format, combined adds referer and user agent, jsonl logs each connection as JSON"`
+
+	AccessLogBuffer        int   `long:"access-log-buffer" env:"ACCESS_LOG_BUFFER" description:"Buffer size in bytes for access log, entries are dropped if it's full instead of slowing down proxy (optional)"`
+	AccessLogFlushInterval timeT `long:"access-log-flush-interval" env:"ACCESS_LOG_FLUSH_INTERVAL" default:"1s" description:"Interval for flushing access log buffer"`
+
+	SlowConnThreshold timeT `long:"slow-conn-threshold" env:"SLOW_CONN_THRESHOLD" description:"Log connections which take longer to connect or in total (optional)"`
+
+	InfluxDBURL      string `long:"influxdb-url" env:"INFLUXDB_URL" description:"InfluxDB write URL for pushing stats in line protocol (optional)\ne.g. http://localhost:8086/api/v2/write?org=org&bucket=bucket"`
+	InfluxDBToken    string `long:"influxdb-token" env:"INFLUXDB_TOKEN" description:"InfluxDB API token (optional)"`
+	InfluxDBInterval timeT  `long:"influxdb-interval" env:"INFLUXDB_INTERVAL" default:"10s" description:"Interval for pushing stats to InfluxDB"`
+
+	OTLPEndpoint string   `long:"otlp-endpoint" env:"OTLP_ENDPOINT" description:"OpenTelemetry collector URL for pushing stats in OTLP/HTTP with JSON encoding (optional)\ne.g. http://localhost:4318/v1/metrics"`
+	OTLPHeaders  []string `long:"otlp-header" env:"OTLP_HEADER" description:"Header of OTLP requests, e.g. for authentication (optional, can be set multiple times, format: key=value)"`
+	OTLPInterval timeT    `long:"otlp-interval" env:"OTLP_INTERVAL" default:"10s" description:"Interval for pushing stats to OpenTelemetry collector"`
+
+	IPFIXCollector string `long:"ipfix-collector" env:"IPFIX_COLLECTOR" description:"IPFIX collector address for exporting connection records (optional, format: host:port)"`
+
+	Capture        string `long:"capture" env:"CAPTURE" description:"File to capture packets in WireGuard network for debugging, e.g. with Wireshark (optional, format: pcap)"`
+	CaptureMaxSize int64  `long:"capture-max-size" env:"CAPTURE_MAX_SIZE" default:"104857600" description:"Maximum size in bytes of capture file, packets are no longer captured after it"`
+
+	Verbose  bool `short:"v" long:"verbose" description:"Show verbose debug information"`
+	PrintULA bool `long:"print-ula" description:"Print IPv6 ULA derived from private key and exit"`
+
+	InterfaceName string `long:"interface-name" env:"INTERFACE_NAME" description:"Name of WireGuard interface in logs and stats (optional, default: tunnel file name)"`
+
+	User  string `long:"user" env:"RUN_AS_USER" description:"User to switch to after binding addresses, e.g. when started as root for privileged ports (optional, Linux only)"`
+	Group string `long:"group" env:"RUN_AS_GROUP" description:"Group to switch to after binding addresses (optional, default: primary group of --user, Linux only)"`
+
+	Tunnels []string `long:"tunnel" env:"TUNNEL" env-delim:"," no-ini:"true" description:"Config file of an additional tunnel (can be set multiple times, see docs)"`
+
+	Stdio string `long:"stdio" env:"STDIO" no-ini:"true" description:"Relay stdin and stdout to this destination through WireGuard network and exit, like inetd or SSH ProxyCommand (format: host:port)"`
+
+	TestMatrix []string `long:"test-matrix" env:"TEST_MATRIX" env-delim:"," no-ini:"true" description:"Connect to this destination through WireGuard network, print pass or fail with latency of each one and exit, nonzero if any fails (can be set multiple times, format: host:port)"`
+
+	Config []string `long:"config" env:"CONFIG" env-delim:"," no-ini:"true" description:"Config file (can be set multiple times, see docs for merge rules)"`
 
 	ClientID string `long:"client-id" env:"CLIENT_ID" hidden:"true"`
 }
+
+// mergePeerEndpoint sets PeerEndpoint from PeerHost and PeerPort, and
+// checks that exactly one form is used.
+func (o *options) mergePeerEndpoint() error {
+	split := o.PeerHost != "" || o.PeerPort != 0
+	switch {
+	case o.PeerEndpoint.host != "" && split:
+		return errors.New("--peer-endpoint can't be used with --peer-host and --peer-port")
+	case o.PeerEndpoint.host != "":
+		return nil
+	case !split:
+		return errors.New("--peer-endpoint, or --peer-host and --peer-port is required")
+	case o.PeerHost == "" || o.PeerPort == 0:
+		return errors.New("--peer-host and --peer-port must be set together")
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(expandEnv(o.PeerHost), "["), "]")
+	o.PeerEndpoint = hostPortT{host, o.PeerPort}
+	return nil
+}
+
+func (o options) tlsCipherSuites() []uint16 {
+	var ciphers []uint16
+	for _, c := range o.TLSCiphers {
+		ciphers = append(ciphers, uint16(c))
+	}
+	return ciphers
+}
+
+// loadConfig parses the config files set by --config into parser.
+//
+// The files are merged in order. Later files override options with single
+// value, and append to options which can be set multiple times. Sections
+// in the files are ignored.
+func loadConfig(parser *flags.Parser) error {
+	var o struct {
+		Config []string `long:"config" env:"CONFIG" env-delim:","`
+	}
+	if _, err := flags.NewParser(&o, flags.IgnoreUnknown).Parse(); err != nil {
+		return err
+	}
+	if len(o.Config) == 0 {
+		return nil
+	}
+
+	var merged bytes.Buffer
+	for _, file := range o.Config {
+		// Check each file alone first for better error messages.
+		if err := flags.NewIniParser(flags.NewParser(&options{}, flags.None)).ParseFile(file); err != nil {
+			return err
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "[") {
+				continue
+			}
+			merged.WriteString(line + "\n")
+		}
+	}
+	return flags.NewIniParser(parser).Parse(&merged)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pushOTLP pushes stats to url of an OpenTelemetry collector every
+// interval, in OTLP/HTTP with JSON encoding. headers are added to requests,
+// e.g. for authentication.
+func (t *tunnel) pushOTLP(url string, headers map[string]string, interval time.Duration, stats func() (any, error)) {
+	client := &http.Client{Timeout: interval}
+	for range time.Tick(interval) {
+		s, err := stats()
+		if err != nil {
+			continue
+		}
+		body, err := otlpMetrics("wghttp", s, t.start, time.Now())
+		if err != nil {
+			t.logger.Verbosef("Encode stats for OTLP: %v", err)
+			continue
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			t.logger.Errorf("Push stats to OTLP: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.logger.Verbosef("Push stats to OTLP: %v", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			t.logger.Verbosef("Push stats to OTLP: %s", resp.Status)
+		}
+	}
+}
+
+// otlpCounter matches the keys of cumulative counters in stats, which are
+// exported as monotonic sums instead of gauges.
+var otlpCounter = regexp.MustCompile(`^(` +
+	`ReceivedBytes|SentBytes|TUNErrors|Handshakes\.Total|` +
+	`EndpointResolve\.(Lookups|Failures)|` +
+	`Connections\.(Total|Denied|Closed\..+|CloseReasons\..+|Protocols\.[^.]+\.Total|Upstream\.Dialed)|` +
+	`DNS\.(Lookups|NotFound|Timeouts|Errors|Latency\..+)` +
+	`)$`)
+
+// aggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE of
+// sums in OTLP.
+const aggregationTemporalityCumulative = 2
+
+// otlpMetrics encodes stats as an ExportMetricsServiceRequest of OTLP in
+// JSON. Like InfluxDB, nested keys are joined with ".", and each number is
+// a metric of doubles named with prefix, with strings as attributes.
+// Counters are cumulative sums since start, and others are gauges at t.
+func otlpMetrics(prefix string, stats any, start, t time.Time) ([]byte, error) {
+	tags, fields, err := flattenStats(stats)
+	if err != nil {
+		return nil, err
+	}
+
+	type value struct {
+		StringValue string `json:"stringValue"`
+	}
+	type attribute struct {
+		Key   string `json:"key"`
+		Value value  `json:"value"`
+	}
+	type dataPoint struct {
+		Attributes        []attribute `json:"attributes"`
+		StartTimeUnixNano string      `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string      `json:"timeUnixNano"`
+		AsDouble          float64     `json:"asDouble"`
+	}
+	type gauge struct {
+		DataPoints []dataPoint `json:"dataPoints"`
+	}
+	type sum struct {
+		DataPoints             []dataPoint `json:"dataPoints"`
+		AggregationTemporality int         `json:"aggregationTemporality"`
+		IsMonotonic            bool        `json:"isMonotonic"`
+	}
+	type metric struct {
+		Name  string `json:"name"`
+		Gauge *gauge `json:"gauge,omitempty"`
+		Sum   *sum   `json:"sum,omitempty"`
+	}
+
+	attributes := []attribute{}
+	for _, k := range sortedKeys(tags) {
+		attributes = append(attributes, attribute{k, value{tags[k]}})
+	}
+	metrics := []metric{}
+	for _, k := range sortedKeys(fields) {
+		f, err := fields[k].Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s of %s", fields[k], k)
+		}
+		dp := dataPoint{Attributes: attributes, TimeUnixNano: strconv.FormatInt(t.UnixNano(), 10), AsDouble: f}
+		m := metric{Name: prefix + "." + k}
+		if otlpCounter.MatchString(k) {
+			dp.StartTimeUnixNano = strconv.FormatInt(start.UnixNano(), 10)
+			m.Sum = &sum{[]dataPoint{dp}, aggregationTemporalityCumulative, true}
+		} else {
+			m.Gauge = &gauge{[]dataPoint{dp}}
+		}
+		metrics = append(metrics, m)
+	}
+
+	type scopeMetrics struct {
+		Scope struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"scope"`
+		Metrics []metric `json:"metrics"`
+	}
+	sm := scopeMetrics{Metrics: metrics}
+	sm.Scope.Name, sm.Scope.Version = prefix, version()
+	type resourceMetrics struct {
+		Resource struct {
+			Attributes []attribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+	}
+	rm := resourceMetrics{ScopeMetrics: []scopeMetrics{sm}}
+	rm.Resource.Attributes = []attribute{{"service.name", value{prefix}}}
+	return json.Marshal(struct {
+		ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+	}{[]resourceMetrics{rm}})
+}
+
+// otlpHeaders parses headers in key=value format.
+func otlpHeaders(values []string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, v := range values {
+		k, val, ok := strings.Cut(v, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid header %q, expect key=value", v)
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(val)
+	}
+	return headers, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOTLPMetrics(t *testing.T) {
+	type protocol struct{ Total, Active int64 }
+	stats := struct {
+		Version     string
+		SentBytes   int64
+		Bandwidth   struct{ Sent float64 }
+		Connections struct {
+			Active    int64
+			Protocols map[string]protocol
+		}
+		DNS struct{ Latency map[string]int64 }
+	}{Version: "v1", SentBytes: 1024}
+	stats.Connections.Active = 1
+	stats.Connections.Protocols = map[string]protocol{"http": {Total: 3, Active: 1}}
+	stats.DNS.Latency = map[string]int64{"0.005": 2}
+
+	data, err := otlpMetrics("wghttp", stats, time.Unix(1, 0), time.Unix(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type dataPoints struct {
+		DataPoints             []map[string]any
+		AggregationTemporality int
+		IsMonotonic            bool
+	}
+	var req struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Name  string
+					Gauge *dataPoints
+					Sum   *dataPoints
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	gauges, sums := map[string]map[string]any{}, map[string]map[string]any{}
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		points, got := m.Gauge, gauges
+		if m.Sum != nil {
+			points, got = m.Sum, sums
+			if m.Gauge != nil || m.Sum.AggregationTemporality != 2 || !m.Sum.IsMonotonic {
+				t.Errorf("%s = %+v, want only monotonic cumulative sum", m.Name, m)
+			}
+		}
+		if points == nil || len(points.DataPoints) != 1 {
+			t.Fatalf("%s = %+v, want one data point", m.Name, m)
+		}
+		got[m.Name] = points.DataPoints[0]
+	}
+	attributes := []any{map[string]any{"key": "Version", "value": map[string]any{"stringValue": "v1"}}}
+	gauge := func(v float64) map[string]any {
+		return map[string]any{"attributes": attributes, "timeUnixNano": "1000000002", "asDouble": v}
+	}
+	sum := func(v float64) map[string]any {
+		return map[string]any{"attributes": attributes, "startTimeUnixNano": "1000000000", "timeUnixNano": "1000000002", "asDouble": v}
+	}
+	wantGauges := map[string]map[string]any{
+		"wghttp.Bandwidth.Sent":                    gauge(0),
+		"wghttp.Connections.Active":                gauge(1),
+		"wghttp.Connections.Protocols.http.Active": gauge(1),
+	}
+	wantSums := map[string]map[string]any{
+		"wghttp.SentBytes":                        sum(1024),
+		"wghttp.Connections.Protocols.http.Total": sum(3),
+		"wghttp.DNS.Latency.0.005":                sum(2),
+	}
+	if !reflect.DeepEqual(gauges, wantGauges) {
+		t.Errorf("otlpMetrics() gauges = %v, want %v", gauges, wantGauges)
+	}
+	if !reflect.DeepEqual(sums, wantSums) {
+		t.Errorf("otlpMetrics() sums = %v, want %v", sums, wantSums)
+	}
+}
+
+func TestOTLPHeaders(t *testing.T) {
+	got, err := otlpHeaders([]string{"Authorization=Bearer a=b,c", " X-Org = org "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"Authorization": "Bearer a=b,c", "X-Org": "org"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("otlpHeaders() = %v, want %v", got, want)
+	}
+
+	for _, v := range []string{"Authorization", "=value", " =value"} {
+		if _, err := otlpHeaders([]string{v}); err == nil {
+			t.Errorf("otlpHeaders(%q) succeeds, want error", v)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// lookupIDs returns the uid of userName, and the gid of groupName, or the
+// primary group of userName if groupName is empty. Names can also be
+// numeric IDs.
+func lookupIDs(userName, groupName string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if _, numErr := strconv.Atoi(userName); err != nil && numErr == nil {
+			u, err = user.LookupId(userName)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("lookup user %s: %w", userName, err)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if _, numErr := strconv.Atoi(groupName); err != nil && numErr == nil {
+			g, err = user.LookupGroupId(groupName)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("lookup group %s: %w", groupName, err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// dropPrivileges switches to the user and group set by --user and --group,
+// after binding privileged ports as root. Supplementary groups are
+// cleared. On Linux, it applies to all threads since Go 1.16. If it's not
+// running as root, there's no privilege to drop, and warn is called unless
+// it's already running as the user and group.
+func dropPrivileges(userName, groupName string, warn func(string, ...any)) error {
+	if userName == "" && groupName == "" {
+		return nil
+	}
+	uid, gid, err := lookupIDs(userName, groupName)
+	if err != nil {
+		return err
+	}
+	// Only root can switch users, e.g. it's run as a systemd user service
+	// with the same config.
+	if euid, egid := syscall.Geteuid(), syscall.Getegid(); euid != 0 {
+		if uid >= 0 && uid != euid || gid >= 0 && gid != egid {
+			warn("Not running as root, keep running as uid %d and gid %d instead of --user and --group", euid, egid)
+		}
+		return nil
+	}
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("clear supplementary groups: %w", err)
+	}
+	if gid >= 0 {
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid %d: %w", gid, err)
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid %d: %w", uid, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestLookupIDs(t *testing.T) {
+	for _, tc := range []struct {
+		user, group string
+		uid, gid    int
+	}{
+		{"", "", -1, -1},
+		{"root", "", 0, 0},
+		{"0", "", 0, 0},
+		{"", "root", -1, 0},
+		{"", "0", -1, 0},
+		{"root", "0", 0, 0},
+	} {
+		uid, gid, err := lookupIDs(tc.user, tc.group)
+		if err != nil {
+			t.Errorf("lookupIDs(%q, %q): %v", tc.user, tc.group, err)
+			continue
+		}
+		if uid != tc.uid || gid != tc.gid {
+			t.Errorf("lookupIDs(%q, %q) = %d, %d, want %d, %d", tc.user, tc.group, uid, gid, tc.uid, tc.gid)
+		}
+	}
+
+	for _, tc := range [][2]string{
+		{"no-such-user", ""},
+		{"", "no-such-group"},
+		{"4294967294", ""},
+	} {
+		if _, _, err := lookupIDs(tc[0], tc[1]); err == nil {
+			t.Errorf("lookupIDs(%q, %q) succeeds, want error", tc[0], tc[1])
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// dropPrivileges is only supported on Linux.
+func dropPrivileges(userName, groupName string, _ func(string, ...any)) error {
+	if userName == "" && groupName == "" {
+		return nil
+	}
+	return errors.New("--user and --group are only supported on Linux")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// readyFilePollInterval is how often the peer is checked for
+	// --ready-file.
+	readyFilePollInterval = time.Second
+	// readyFileWindow is the window to check whether the peer responds
+	// to packets sent.
+	readyFileWindow = 30 * time.Second
+)
+
+// watchReadyFile writes the file of --ready-file once there's a handshake
+// with peer, and updates it on each new one. The peer is checked with
+// readStatus every interval, until done is closed. The file is removed when
+// the peer doesn't respond: there's no handshake in staleAfter, and packets
+// are sent but nothing is received in the last window. It's also removed at
+// exit.
+func (t *tunnel) watchReadyFile(path string, staleAfter, interval time.Duration, readStatus func() (peerStatus, error), done <-chan struct{}) {
+	// The file may be left by a previous run which crashed.
+	_ = os.Remove(path)
+	atExit(func() { _ = os.Remove(path) })
+
+	var ready bool
+	var handshake int64
+	var window peerStatus
+	windowStart := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		status, err := readStatus()
+		if err != nil {
+			continue
+		}
+
+		if status.LastHandshakeTimestamp != 0 && status.LastHandshakeTimestamp != handshake {
+			handshake = status.LastHandshakeTimestamp
+			if err := writeReadyFile(path, status); err != nil {
+				t.logger.Errorf("Write ready file: %v", err)
+				continue
+			}
+			if !ready {
+				t.logger.Verbosef("Tunnel is ready, wrote %s", path)
+			}
+			ready = true
+		}
+
+		if time.Since(windowStart) < readyFileWindow {
+			continue
+		}
+		stale := time.Since(time.Unix(status.LastHandshakeTimestamp, 0)) > staleAfter &&
+			status.SentBytes > window.SentBytes &&
+			status.ReceivedBytes == window.ReceivedBytes
+		window, windowStart = status, time.Now()
+		if ready && stale {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				t.logger.Errorf("Remove ready file: %v", err)
+				continue
+			}
+			t.logger.Errorf("Peer doesn't respond, removed ready file %s", path)
+			ready = false
+		}
+	}
+}
+
+// writeReadyFile replaces the file at path with status atomically, by
+// renaming a temporary file in the same directory.
+func writeReadyFile(path string, status peerStatus) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintf(f, "endpoint=%s\nlast_handshake_time_sec=%d\n", status.Endpoint, status.LastHandshakeTimestamp)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchReadyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wghttp.ready")
+	// It's left by a previous run.
+	if err := os.WriteFile(path, []byte("last_handshake_time_sec=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	exists := func() bool {
+		_, err := os.Stat(path)
+		return !errors.Is(err, fs.ErrNotExist)
+	}
+
+	var status atomic.Pointer[peerStatus]
+	status.Store(&peerStatus{Endpoint: "192.0.2.1:51820"})
+	readStatus := func() (peerStatus, error) { return *status.Load(), nil }
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		newTestTunnel().watchReadyFile(path, time.Minute, time.Millisecond, readStatus, done)
+		close(stopped)
+	}()
+
+	if !waitFor(func() bool { return !exists() }) {
+		t.Fatal("file of previous run isn't removed")
+	}
+	// Still no handshake.
+	time.Sleep(10 * time.Millisecond)
+	if exists() {
+		t.Fatal("file is written before handshake")
+	}
+
+	status.Store(&peerStatus{Endpoint: "192.0.2.1:51820", LastHandshakeTimestamp: 1700000000})
+	want := "endpoint=192.0.2.1:51820\nlast_handshake_time_sec=1700000000\n"
+	if !waitFor(func() bool {
+		data, _ := os.ReadFile(path)
+		return string(data) == want
+	}) {
+		data, _ := os.ReadFile(path)
+		t.Fatalf("file = %q after handshake, want %q", data, want)
+	}
+	// No temporary file is left.
+	if files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*")); len(files) != 1 {
+		t.Errorf("files = %q, want only the ready file", files)
+	}
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watching doesn't stop")
+	}
+	runExitHooks()
+	if exists() {
+		t.Error("file isn't removed at exit")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/zhsj/wghttp/internal/ipfix"
+	"github.com/zhsj/wghttp/internal/proxy"
+)
+
+// exportFlows exports the record as two flows, from client to destination
+// and backwards.
+func (t *tunnel) exportFlows(exporter *ipfix.Exporter, r proxy.Record) {
+	client, ok := addrPort(r.Client)
+	if !ok {
+		return
+	}
+	dst, ok := addrPort(r.Destination)
+	if !ok {
+		return
+	}
+
+	err := exporter.Export(
+		ipfix.Flow{Src: client, Dst: dst, Bytes: uint64(r.SentBytes), Start: r.Start, End: r.End},
+		ipfix.Flow{Src: dst, Dst: client, Bytes: uint64(r.ReceivedBytes), Start: r.Start, End: r.End},
+	)
+	if err != nil {
+		t.logger.Verbosef("Export IPFIX flows: %v", err)
+	}
+}
+
+// logSlowConn logs the record if dialing or the whole connection takes
+// longer than threshold.
+func (t *tunnel) logSlowConn(r proxy.Record, threshold time.Duration) {
+	duration := r.End.Sub(r.Start)
+	if r.DialDuration <= threshold && duration <= threshold {
+		return
+	}
+	t.logger.Errorf(
+		"Slow connection: %s from %v to %s (%v), dial %v, duration %v, sent %d bytes, received %d bytes",
+		r.Protocol, r.Client, r.Host, r.Destination,
+		r.DialDuration.Round(time.Millisecond), duration.Round(time.Millisecond),
+		r.SentBytes, r.ReceivedBytes,
+	)
+}
+
+func addrPort(addr net.Addr) (netip.AddrPort, bool) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	ap := tcpAddr.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), true
+}
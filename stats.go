@@ -3,51 +3,111 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"net/netip"
 	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.zx2c4.com/wireguard/device"
+
+	"github.com/zhsj/wghttp/internal/proxy"
+	"github.com/zhsj/wghttp/internal/resolver"
 )
 
-func stats(dev *device.Device) func() (any, error) {
+// peerStatus is the status of WireGuard peer in device.
+type peerStatus struct {
+	Endpoint               string
+	LastHandshakeTimestamp int64
+	ReceivedBytes          int64
+	SentBytes              int64
+}
+
+func readPeerStatus(dev *device.Device) (peerStatus, error) {
+	var status peerStatus
+	var buf bytes.Buffer
+	if err := dev.IpcGetOperation(&buf); err != nil {
+		return status, err
+	}
+
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if prefix := "endpoint="; strings.HasPrefix(line, prefix) {
+			status.Endpoint = strings.TrimPrefix(line, prefix)
+		}
+		if prefix := "last_handshake_time_sec="; strings.HasPrefix(line, prefix) {
+			status.LastHandshakeTimestamp, _ = strconv.ParseInt(strings.TrimPrefix(line, prefix), 10, 64)
+		}
+		if prefix := "rx_bytes="; strings.HasPrefix(line, prefix) {
+			status.ReceivedBytes, _ = strconv.ParseInt(strings.TrimPrefix(line, prefix), 10, 64)
+		}
+		if prefix := "tx_bytes="; strings.HasPrefix(line, prefix) {
+			status.SentBytes, _ = strconv.ParseInt(strings.TrimPrefix(line, prefix), 10, 64)
+		}
+	}
+	return status, nil
+}
+
+func (t *tunnel) stats(counters *proxy.Counters, dnsStats *resolver.Stats, handshakes *handshakeMonitor) func() (any, error) {
 	return func() (any, error) {
-		var buf bytes.Buffer
-		if err := dev.IpcGetOperation(&buf); err != nil {
-			logger.Errorf("Get device config: %v", err)
+		status, err := readPeerStatus(t.dev.Load())
+		if err != nil {
+			t.logger.Errorf("Get device config: %v", err)
 			return nil, err
 		}
 
+		var endpointResolve *resolveStats
+		if _, err := netip.ParseAddr(t.opts.PeerEndpoint.host); err != nil {
+			endpointResolve = &t.resolveStats
+		}
 		stats := struct {
-			Endpoint               string
-			LastHandshakeTimestamp int64
-			ReceivedBytes          int64
-			SentBytes              int64
+			Tunnel string `json:",omitempty"`
+			Stage  string
+			peerStatus
+			// EndpointGeo describes the address of endpoint from --geoip
+			// files.
+			EndpointGeo string `json:",omitempty"`
+			Handshakes  *handshakeMonitor
+			// EndpointResolve is set if the endpoint is a domain,
+			// which is resolved every --resolve-interval.
+			EndpointResolve *resolveStats `json:",omitempty"`
+			// TUNErrors counts errors of reading from and writing
+			// to TUN device.
+			TUNErrors int64
+
+			Connections *proxy.Counters
+			DNS         *resolver.Stats
+
+			// StartTimestamp is when the tunnel is started, and Uptime
+			// is the seconds since then.
+			StartTimestamp int64
+			Uptime         int64
 
 			NumGoroutine int
+			Verbose      bool
 			Version      string
 		}{
+			Tunnel:      t.name,
+			Stage:       t.currentStage(),
+			peerStatus:  status,
+			EndpointGeo: t.endpointGeo(status.Endpoint),
+			Handshakes:  handshakes,
+
+			EndpointResolve: endpointResolve,
+			TUNErrors:       t.tunErrors.Load(),
+
+			Connections: counters,
+			DNS:         dnsStats,
+
+			StartTimestamp: t.start.Unix(),
+			Uptime:         int64(time.Since(t.start).Seconds()),
+
 			NumGoroutine: runtime.NumGoroutine(),
+			Verbose:      t.verbose.Load(),
 			Version:      version(),
 		}
-
-		scanner := bufio.NewScanner(&buf)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if prefix := "endpoint="; strings.HasPrefix(line, prefix) {
-				stats.Endpoint = strings.TrimPrefix(line, prefix)
-			}
-			if prefix := "last_handshake_time_sec="; strings.HasPrefix(line, prefix) {
-				stats.LastHandshakeTimestamp, _ = strconv.ParseInt(strings.TrimPrefix(line, prefix), 10, 64)
-			}
-			if prefix := "rx_bytes="; strings.HasPrefix(line, prefix) {
-				stats.ReceivedBytes, _ = strconv.ParseInt(strings.TrimPrefix(line, prefix), 10, 64)
-			}
-			if prefix := "tx_bytes="; strings.HasPrefix(line, prefix) {
-				stats.SentBytes, _ = strconv.ParseInt(strings.TrimPrefix(line, prefix), 10, 64)
-			}
-		}
 		return stats, nil
 	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/zhsj/wghttp/internal/proxy"
+)
+
+// relayStdio relays stdin and stdout to the destination of --stdio as a
+// single proxy client, without listening on any address.
+func (t *tunnel) relayStdio() error {
+	if err := t.checkKnownPeers(); err != nil {
+		return err
+	}
+	if _, _, err := t.setupNet(); err != nil {
+		return err
+	}
+
+	proxier, err := t.standaloneProxy()
+	if err != nil {
+		return err
+	}
+
+	stdio := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+	return proxier.Relay(context.Background(), stdio, t.opts.Stdio)
+}
+
+// standaloneProxy returns the proxy to dial destinations directly, for
+// modes without listening on any address.
+func (t *tunnel) standaloneProxy() (proxy.Proxy, error) {
+	proxier := proxy.Proxy{
+		Dial: t.proxyDialer(),
+		DNS:  t.opts.DNS,
+
+		DialTimeout: time.Duration(t.opts.DialTimeout) * time.Second,
+
+		DNSRetransmits:        t.opts.DNSRetransmits,
+		DNSRetransmitInterval: time.Duration(t.opts.DNSRetransmitInterval) * time.Second,
+		DNSTLSMinVersion:      uint16(t.opts.TLSMinVersion),
+		DNSTLSCipherSuites:    t.opts.tlsCipherSuites(),
+	}
+	if t.opts.ExitMode == "remote" {
+		proxier.Network = t.tunnelNetwork()
+	}
+	if err := t.setupDNSReplay(&proxier); err != nil {
+		return proxier, err
+	}
+	return proxier, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// tunErrorBackoff is how long to wait before reading again after an error,
+// to avoid spinning on a persistent one.
+const tunErrorBackoff = 10 * time.Millisecond
+
+// errorTUN counts errors of reading from and writing to the TUN device of
+// netstack, and handles them by --tun-error. Without it, WireGuard closes
+// the device on the first read error, e.g. io.EOF of netstack reading an
+// empty packet, which leaves the proxy running without network.
+type errorTUN struct {
+	t *tunnel
+	// rebuild creates a device to replace the current one on error if it's
+	// set, otherwise reading continues after errors.
+	rebuild func() (tun.Device, error)
+
+	mu     sync.Mutex
+	dev    tun.Device
+	closed bool
+}
+
+func (d *errorTUN) current() tun.Device {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dev
+}
+
+func (d *errorTUN) Read(buf []byte, offset int) (int, error) {
+	for {
+		dev := d.current()
+		n, err := dev.Read(buf, offset)
+		if err == nil {
+			return n, nil
+		}
+		if errors.Is(err, os.ErrClosed) {
+			if dev != d.current() {
+				// It's replaced by a write error.
+				continue
+			}
+			return n, err
+		}
+		d.handle("read from", dev, err)
+		time.Sleep(tunErrorBackoff)
+	}
+}
+
+func (d *errorTUN) Write(buf []byte, offset int) (int, error) {
+	dev := d.current()
+	n, err := dev.Write(buf, offset)
+	if err != nil && !errors.Is(err, os.ErrClosed) {
+		d.handle("write to", dev, err)
+	}
+	return n, err
+}
+
+// handle counts and logs err of dev, and replaces dev if rebuild is set.
+func (d *errorTUN) handle(op string, dev tun.Device, err error) {
+	n := d.t.tunErrors.Add(1)
+	// Only errors 1, 2, 4, 8... are logged, in case they keep happening.
+	if n&(n-1) == 0 {
+		d.t.logger.Errorf("Failed to %s TUN device (%d errors): %v", op, n, err)
+	}
+	if d.rebuild != nil {
+		d.replace(dev)
+	}
+}
+
+// replace replaces dev with a new device, unless it's already replaced
+// after an error of another read or write. Connections through the old
+// device are broken, and new ones use the new device. It keeps using dev
+// if a new one can't be created.
+func (d *errorTUN) replace(dev tun.Device) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed || dev != d.dev {
+		return
+	}
+	newDev, err := d.rebuild()
+	if err != nil {
+		d.t.logger.Errorf("Rebuild TUN device: %v", err)
+		return
+	}
+	d.dev = newDev
+	dev.Close()
+	d.t.logger.Errorf("TUN device is rebuilt")
+}
+
+func (d *errorTUN) File() *os.File { return d.current().File() }
+
+func (d *errorTUN) Flush() error { return d.current().Flush() }
+
+func (d *errorTUN) MTU() (int, error) { return d.current().MTU() }
+
+func (d *errorTUN) Name() (string, error) { return d.current().Name() }
+
+// Events returns events of the current device. WireGuard stops reading
+// them once it's replaced and closed, which is fine for netstack as it
+// only sends EventUp on creation.
+func (d *errorTUN) Events() <-chan tun.Event { return d.current().Events() }
+
+func (d *errorTUN) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	return d.dev.Close()
+}
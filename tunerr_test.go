@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// fakeTUN reads a packet for each nil in reads, or fails with the error,
+// and fails with os.ErrClosed once reads run out or it's closed.
+type fakeTUN struct {
+	reads    []error
+	writeErr error
+	written  int
+	closed   bool
+}
+
+func (f *fakeTUN) Read(buf []byte, offset int) (int, error) {
+	if f.closed || len(f.reads) == 0 {
+		return 0, os.ErrClosed
+	}
+	err := f.reads[0]
+	f.reads = f.reads[1:]
+	if err != nil {
+		return 0, err
+	}
+	return copy(buf[offset:], "packet"), nil
+}
+
+func (f *fakeTUN) Write(buf []byte, offset int) (int, error) {
+	if f.writeErr != nil {
+		return 0, f.writeErr
+	}
+	f.written++
+	return len(buf) - offset, nil
+}
+
+func (f *fakeTUN) File() *os.File           { return nil }
+func (f *fakeTUN) Flush() error             { return nil }
+func (f *fakeTUN) MTU() (int, error)        { return 1420, nil }
+func (f *fakeTUN) Name() (string, error)    { return "fake", nil }
+func (f *fakeTUN) Events() <-chan tun.Event { return nil }
+func (f *fakeTUN) Close() error             { f.closed = true; return nil }
+
+func newTestTunnel() *tunnel {
+	return &tunnel{logger: device.NewLogger(device.LogLevelSilent, "")}
+}
+
+func TestErrorTUNContinue(t *testing.T) {
+	tn := newTestTunnel()
+	fake := &fakeTUN{reads: []error{io.EOF, io.ErrUnexpectedEOF, nil}, writeErr: errors.New("no buffer")}
+	d := &errorTUN{t: tn, dev: fake}
+
+	buf := make([]byte, 16)
+	n, err := d.Read(buf, 4)
+	if err != nil || string(buf[4:4+n]) != "packet" {
+		t.Fatalf("Read() = %q, %v, want packet after errors", buf[4:4+n], err)
+	}
+	if _, err := d.Write(buf, 4); err == nil {
+		t.Error("Write() succeeds, want error of device")
+	}
+	if got := tn.tunErrors.Load(); got != 3 {
+		t.Errorf("TUN errors = %d, want 3", got)
+	}
+	if d.current() != fake || fake.closed {
+		t.Error("device is replaced without rebuild")
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Read(buf, 4); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Read() after Close = %v, want %v", err, os.ErrClosed)
+	}
+}
+
+func TestErrorTUNRebuild(t *testing.T) {
+	tn := newTestTunnel()
+	first := &fakeTUN{reads: []error{io.EOF}}
+	rebuilt := []*fakeTUN{}
+	d := &errorTUN{t: tn, dev: first, rebuild: func() (tun.Device, error) {
+		dev := &fakeTUN{reads: []error{nil}}
+		rebuilt = append(rebuilt, dev)
+		return dev, nil
+	}}
+
+	buf := make([]byte, 16)
+	n, err := d.Read(buf, 0)
+	if err != nil || string(buf[:n]) != "packet" {
+		t.Fatalf("Read() = %q, %v, want packet of rebuilt device", buf[:n], err)
+	}
+	if !first.closed || len(rebuilt) != 1 || d.current() != rebuilt[0] {
+		t.Fatalf("device isn't replaced after read error")
+	}
+
+	// A write error replaces the device again, and later writes go to the
+	// new one.
+	rebuilt[0].writeErr = errors.New("no buffer")
+	if _, err := d.Write(buf, 0); err == nil {
+		t.Error("Write() succeeds, want error of device")
+	}
+	if len(rebuilt) != 2 || !rebuilt[0].closed {
+		t.Fatalf("device isn't replaced after write error")
+	}
+	if _, err := d.Write(buf, 0); err != nil || rebuilt[1].written != 1 {
+		t.Errorf("Write() = %v, want written to rebuilt device", err)
+	}
+
+	// An error of a replaced device doesn't replace the current one.
+	d.handle("read from", first, io.EOF)
+	if len(rebuilt) != 2 {
+		t.Errorf("current device is replaced by error of old one")
+	}
+	if got := tn.tunErrors.Load(); got != 3 {
+		t.Errorf("TUN errors = %d, want 3", got)
+	}
+}
+
+func TestErrorTUNRebuildFailure(t *testing.T) {
+	fake := &fakeTUN{reads: []error{io.EOF, nil}}
+	d := &errorTUN{t: newTestTunnel(), dev: fake, rebuild: func() (tun.Device, error) {
+		return nil, errors.New("out of memory")
+	}}
+
+	buf := make([]byte, 16)
+	if n, err := d.Read(buf, 0); err != nil || string(buf[:n]) != "packet" {
+		t.Errorf("Read() = %q, %v, want packet of old device", buf[:n], err)
+	}
+	if d.current() != fake || fake.closed {
+		t.Error("device is replaced though rebuild fails")
+	}
+}
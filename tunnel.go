@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/zhsj/wghttp/internal/proxy"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// tunnel is a WireGuard device with its proxy. The main tunnel is
+// configured by command line, and others by --tunnel files.
+type tunnel struct {
+	// name is set by --interface-name, or the file name for tunnels
+	// other than the main one.
+	name   string
+	opts   options
+	logger *device.Logger
+	// logOut is where logs are written, which is stdout unless it's
+	// used by --stdio.
+	logOut io.Writer
+	// verbose is initialized by --verbose, and toggled by SIGUSR2.
+	verbose atomic.Bool
+	// geoIPs are loaded from --geoip files, and geoIPErr logs the first
+	// error of them.
+	geoIPs   []*geoIP
+	geoIPErr sync.Once
+	// start is when the tunnel is started, and stage is the step of
+	// setting it up, which is ready once the proxy is served.
+	start time.Time
+	stage atomic.Value
+	// counters of the proxy, which is set once it's served.
+	counters atomic.Pointer[proxy.Counters]
+	// dev and tnet are the WireGuard device and its network, which are set
+	// once the device is up. tnet is replaced when netstack is rebuilt by
+	// --tun-error=rebuild.
+	dev  atomic.Pointer[device.Device]
+	tnet atomic.Pointer[netstack.Net]
+	// tunErrors counts errors of reading from and writing to the TUN
+	// device of netstack.
+	tunErrors atomic.Int64
+	// resolveStats counts resolving peer endpoint every --resolve-interval.
+	resolveStats resolveStats
+	// ready is called when all addresses are bound, and returns when the
+	// proxy can be served, after dropping privileges.
+	ready func()
+}
+
+// loadTunnel loads a tunnel from an ini file with the same options as
+// config file. Options not set in the file fall back to defaults, but not
+// to environment variables, which are for the main tunnel, so that tunnels
+// don't share keys or listen addresses.
+func loadTunnel(file string) (*tunnel, error) {
+	t := &tunnel{name: strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))}
+	parser := flags.NewParser(&t.opts, flags.None)
+	clearEnv(parser.Group)
+	if err := flags.NewIniParser(parser).ParseFile(file); err != nil {
+		return nil, err
+	}
+	if _, err := parser.ParseArgs(nil); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if err := t.opts.mergePeerEndpoint(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if t.opts.InterfaceName != "" {
+		t.name = t.opts.InterfaceName
+	}
+	return t, nil
+}
+
+// clearEnv disables environment variables of options in g and its
+// subgroups.
+func clearEnv(g *flags.Group) {
+	for _, o := range g.Options() {
+		o.EnvDefaultKey = ""
+	}
+	for _, sub := range g.Groups() {
+		clearEnv(sub)
+	}
+}
+
+func (t *tunnel) logPrefix() string {
+	if t.name == "" {
+		return ""
+	}
+	return t.name + ": "
+}
+
+// setStage records the step of setting up the tunnel, for admin server.
+func (t *tunnel) setStage(stage string) {
+	t.stage.Store(stage)
+	t.logger.Verbosef("Stage: %s", stage)
+}
+
+func (t *tunnel) currentStage() string {
+	stage, _ := t.stage.Load().(string)
+	return stage
+}
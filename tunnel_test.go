@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTunnelIgnoresEnv(t *testing.T) {
+	const (
+		envKey  = "QST67iZm1OWeMtHlPMWLho5M/ddHtHmNOGdRGrWd/zU="
+		peerKey = "GtL7fZc/bLnqZldpVofMCD6hDjrK28SsdLxevJ+qtKU="
+	)
+	// They are for the main tunnel only.
+	t.Setenv("PRIVATE_KEY", envKey)
+	t.Setenv("LISTEN", "localhost:18080")
+	t.Setenv("ADMIN_LISTEN", "localhost:18081")
+	t.Setenv("READY_FILE", "/run/wghttp.ready")
+
+	dir := t.TempDir()
+	load := func(name string, lines ...string) (*tunnel, error) {
+		file := filepath.Join(dir, name+".conf")
+		if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return loadTunnel(file)
+	}
+
+	var tunnels []*tunnel
+	for _, tc := range []struct{ name, key, listen string }{
+		{"home", "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=", "localhost:8081"},
+		{"work", "gI6EdUSYvn8ugXOt8QQD6Yc+JyiZxIhp3GInSWRfWGE=", "localhost:8082"},
+	} {
+		tn, err := load(tc.name,
+			"client-ip = 10.0.0.2",
+			"private-key = "+tc.key,
+			"peer-key = "+peerKey,
+			"peer-endpoint = 192.0.2.1:51820",
+			"listen = "+tc.listen,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tunnels = append(tunnels, tn)
+	}
+	if tunnels[0].opts.PrivateKey == tunnels[1].opts.PrivateKey {
+		t.Error("tunnels share private key")
+	}
+	for _, tn := range tunnels {
+		if tn.opts.PrivateKey.base64() == envKey {
+			t.Errorf("%s: private key is from environment", tn.name)
+		}
+		if tn.opts.Listen == "localhost:18080" || tn.opts.AdminListen != "" || tn.opts.ReadyFile != "" {
+			t.Errorf("%s: listen %q, admin listen %q and ready file %q, want them not from environment",
+				tn.name, tn.opts.Listen, tn.opts.AdminListen, tn.opts.ReadyFile)
+		}
+	}
+	if tunnels[0].opts.Listen == tunnels[1].opts.Listen {
+		t.Errorf("tunnels share listen address %s", tunnels[0].opts.Listen)
+	}
+
+	// The private key in environment isn't used for a file without it.
+	if _, err := load("nokey",
+		"client-ip = 10.0.0.2",
+		"peer-key = "+peerKey,
+		"peer-endpoint = 192.0.2.1:51820",
+	); err == nil || !strings.Contains(err.Error(), "private-key") {
+		t.Errorf("loading tunnel without private key: error %v, want it required", err)
+	}
+}
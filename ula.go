@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// printULA prints the ULA derived from privateKey for --print-ula.
+func printULA(privateKey keyT) error {
+	if privateKey == "" {
+		return errors.New("--print-ula requires --private-key")
+	}
+	ip, err := deriveULA(privateKey)
+	if err != nil {
+		return fmt.Errorf("derive ULA: %w", err)
+	}
+	fmt.Println(ip)
+	return nil
+}
+
+// deriveULA returns a stable Unique Local Address (RFC 4193) for the
+// private key.
+//
+// With h = SHA-256(public key), the address is fd00::/8, followed by
+// h[0:5] as Global ID, 0 as Subnet ID and h[5:13] as Interface ID.
+func deriveULA(privateKey keyT) (netip.Addr, error) {
+	priv, err := hex.DecodeString(string(privateKey))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	h := sha256.Sum256(pub)
+
+	var ip [16]byte
+	ip[0] = 0xfd
+	copy(ip[1:6], h[0:5])
+	copy(ip[8:16], h[5:13])
+	return netip.AddrFrom16(ip), nil
+}
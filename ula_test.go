@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestDeriveULA(t *testing.T) {
+	// The private key of RFC 7748 section 6.1, whose public key is
+	// 8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a.
+	ip, err := deriveULA("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "fd30:c9c:9603:0:b92a:4b39:ed39:58bf"; ip.String() != want {
+		t.Errorf("deriveULA() = %s, want %s", ip, want)
+	}
+
+	if _, err := deriveULA("not hex"); err == nil {
+		t.Error("deriveULA() of invalid key succeeds, want error")
+	}
+}
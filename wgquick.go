@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/zhsj/wghttp/internal/resolver"
+)
+
+// wgQuickConf returns the running config as a wg-quick file, for handing
+// off the tunnel to kernel WireGuard. Keys are redacted unless keys is set.
+func (t *tunnel) wgQuickConf() func(keys bool) (string, error) {
+	return func(keys bool) (string, error) {
+		var buf bytes.Buffer
+		if err := t.dev.Load().IpcGetOperation(&buf); err != nil {
+			return "", err
+		}
+		listenPort := ""
+		scanner := bufio.NewScanner(&buf)
+		for scanner.Scan() {
+			if prefix := "listen_port="; strings.HasPrefix(scanner.Text(), prefix) {
+				listenPort = strings.TrimPrefix(scanner.Text(), prefix)
+			}
+		}
+
+		key := func(name string, k keyT) string {
+			if !keys {
+				return fmt.Sprintf("# %s = <redacted>\n", name)
+			}
+			return fmt.Sprintf("%s = %s\n", name, k.base64())
+		}
+
+		var b strings.Builder
+		b.WriteString("[Interface]\n")
+		b.WriteString(key("PrivateKey", t.opts.PrivateKey))
+		addrs := []string{}
+		for _, ip := range t.opts.ClientIPs {
+			addrs = append(addrs, netip.PrefixFrom(netip.Addr(ip), netip.Addr(ip).BitLen()).String())
+		}
+		fmt.Fprintf(&b, "Address = %s\n", strings.Join(addrs, ", "))
+		if listenPort != "" && listenPort != "0" {
+			fmt.Fprintf(&b, "ListenPort = %s\n", listenPort)
+		}
+		if t.opts.DNS != "" {
+			// wg-quick only supports plain DNS on port 53.
+			if ip, ok := resolver.ServerIP(t.opts.DNS); ok && (t.opts.DNS == ip.String() || t.opts.DNS == "udp://"+ip.String()) {
+				fmt.Fprintf(&b, "DNS = %s\n", ip)
+			} else {
+				fmt.Fprintf(&b, "# DNS = %s is not supported by wg-quick\n", t.opts.DNS)
+			}
+		}
+		fmt.Fprintf(&b, "MTU = %d\n", t.opts.MTU)
+
+		b.WriteString("\n[Peer]\n")
+		fmt.Fprintf(&b, "PublicKey = %s\n", t.opts.PeerKey.base64())
+		if t.opts.PresharedKey != "" {
+			b.WriteString(key("PresharedKey", t.opts.PresharedKey))
+		}
+		allowedIPs := []string{}
+		for _, prefix := range t.opts.AllowedIPs {
+			allowedIPs = append(allowedIPs, prefix.String())
+		}
+		fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(allowedIPs, ", "))
+		fmt.Fprintf(&b, "Endpoint = %s\n", t.opts.PeerEndpoint)
+		if t.opts.KeepaliveInterval > 0 {
+			fmt.Fprintf(&b, "PersistentKeepalive = %d\n", t.opts.KeepaliveInterval)
+		}
+		return b.String(), nil
+	}
+}